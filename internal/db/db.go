@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
 	"errors"
 
 	"github.com/acme-sky/workers/internal/models"
+	"github.com/acme-sky/workers/internal/tracing"
+	"github.com/charmbracelet/log"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -28,7 +31,13 @@ func InitDb(dsn string) (*gorm.DB, error) {
 			&models.Airline{},
 			&models.Invoice{},
 			&models.User{},
+			&models.Deployment{},
+			&models.ProcessInstance{},
 		)
+
+		if err := tracing.RegisterGorm(db); err != nil {
+			log.Warnf("Queries are not traced: %s", err.Error())
+		}
 	}
 
 	return db, err
@@ -41,3 +50,46 @@ func GetDb() (*gorm.DB, error) {
 	}
 	return db, nil
 }
+
+// Replace the instance, e.g. with a transaction in tests. It returns the
+// previous one so it can be restored.
+func SetDb(conn *gorm.DB) *gorm.DB {
+	previous := db
+	db = conn
+	return previous
+}
+
+// Return the instance bound to `ctx`, so its queries are traced as children of
+// the span in the context
+func WithContext(ctx context.Context) (*gorm.DB, error) {
+	if db == nil {
+		return nil, errors.New("You must call `InitDb()` first.")
+	}
+	return db.WithContext(ctx), nil
+}
+
+// Check the connection to the database
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return errors.New("You must call `InitDb()` first.")
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+// Close the connection pool of the database, if opened
+func CloseDb() error {
+	if db == nil {
+		return nil
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
+}
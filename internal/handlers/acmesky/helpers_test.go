@@ -0,0 +1,125 @@
+package handlers_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/acme-sky/workers/internal/db"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+	"github.com/acme-sky/workers/internal/models"
+	"gorm.io/gorm"
+)
+
+// Returns the database of the test, rolled back at the end
+func testDb(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	jobtest.UseDatabase(t)
+	conn, err := db.GetDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+// Create a user with a unique username
+func createUser(t *testing.T, conn *gorm.DB) models.User {
+	t.Helper()
+
+	name := fmt.Sprintf("test%d", jobtest.NextKey())
+	user := models.User{Name: name, Username: name, Email: name + "@example.com", Password: "password"}
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %s", err.Error())
+	}
+
+	return user
+}
+
+// Create an available flight for `user` departing in `days`
+func createFlight(t *testing.T, conn *gorm.DB, user models.User, interestId *int, days int) models.AvailableFlight {
+	t.Helper()
+
+	departure := time.Now().AddDate(0, 0, days)
+	flight := models.AvailableFlight{
+		CreatedAt:        time.Now(),
+		Airline:          "ACME Airline",
+		DepartureTime:    departure,
+		DepartureAirport: "BLQ",
+		ArrivalTime:      departure.Add(2 * time.Hour),
+		ArrivalAirport:   "CPH",
+		Code:             fmt.Sprintf("AC%d", jobtest.NextKey()%10000),
+		Cost:             100,
+		InterestId:       interestId,
+		UserId:           int(user.ID),
+	}
+	if err := conn.Create(&flight).Error; err != nil {
+		t.Fatalf("failed to create flight: %s", err.Error())
+	}
+
+	return flight
+}
+
+// Create an interest of `user`
+func createInterest(t *testing.T, conn *gorm.DB, user models.User) models.Interest {
+	t.Helper()
+
+	departure := time.Now().AddDate(0, 0, 7)
+	interest := models.Interest{
+		CreatedAt:               time.Now(),
+		Flight1DepartureTime:    departure,
+		Flight1DepartureAirport: "BLQ",
+		Flight1ArrivalTime:      departure.Add(2 * time.Hour),
+		Flight1ArrivalAirport:   "CPH",
+		UserId:                  int(user.ID),
+	}
+	if err := conn.Create(&interest).Error; err != nil {
+		t.Fatalf("failed to create interest: %s", err.Error())
+	}
+
+	return interest
+}
+
+// Create a journey of `user` with `flight1` and optionally `flight2`
+func createJourney(t *testing.T, conn *gorm.DB, user models.User, flight1 models.AvailableFlight, flight2 *models.AvailableFlight) models.Journey {
+	t.Helper()
+
+	journey := models.Journey{
+		CreatedAt: time.Now(),
+		Flight1Id: int(flight1.Id),
+		Cost:      flight1.Cost,
+		UserId:    int(user.ID),
+	}
+	if flight2 != nil {
+		id := int(flight2.Id)
+		journey.Flight2Id = &id
+		journey.Cost += flight2.Cost
+	}
+	if err := conn.Create(&journey).Error; err != nil {
+		t.Fatalf("failed to create journey: %s", err.Error())
+	}
+
+	return journey
+}
+
+// Create an offer of `user` for `journey` with `token`, expiring after
+// `validity`
+func createOffer(t *testing.T, conn *gorm.DB, user models.User, journey models.Journey, token string, validity time.Duration) models.Offer {
+	t.Helper()
+
+	offer := models.Offer{
+		CreatedAt: time.Now(),
+		Message:   "Test offer",
+		Expired:   strconv.FormatInt(time.Now().Add(validity).Unix(), 10),
+		Token:     token,
+		JourneyId: int(journey.Id),
+		UserId:    int(user.ID),
+	}
+	if err := conn.Create(&offer).Error; err != nil {
+		t.Fatalf("failed to create offer: %s", err.Error())
+	}
+
+	return offer
+}
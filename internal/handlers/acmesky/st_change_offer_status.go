@@ -3,50 +3,42 @@ package handlers
 import (
 	"context"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/db"
-	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/message"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `STChangeOfferStatus`
+type ChangeOfferStatusInput struct {
+	OfferId uint `json:"offer_id" binding:"required"`
+}
+
 // Service Task raised when an offer token is valid.
 // It changes its "is_used" to `true`.
-func STChangeOfferStatus(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+func STChangeOfferStatus(ctx context.Context, job entities.Job, in ChangeOfferStatusInput) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var offer models.Offer
 
-	if err := db.Where("id = ?", int(variables["offer_id"].(float64))).First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Error on getting offer %s", job.Type, jobKey, err.Error())
+	if err := db.Where("id = ?", in.OfferId).First(&offer).Error; err != nil {
+		logger.Error("Error on getting offer", "err", err)
+		return nil, err
 	}
+	used := offer.IsUsed
 	offer.IsUsed = true
 	if err := db.Save(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Error on saving offer %s", job.Type, jobKey, err.Error())
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error on saving offer", "err", err)
+	} else if !used {
+		metrics.TokensRedeemed.Inc()
+		message.Emit(ctx, message.JobKey(job), message.EventOfferRedeemed, map[string]interface{}{
+			"offer_id": offer.Id,
+			"user_id":  offer.UserId,
+		})
 	}
 
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return nil, nil
 }
@@ -2,35 +2,32 @@ package handlers
 
 import (
 	"context"
-
-	"github.com/charmbracelet/log"
+	"errors"
 
 	"github.com/acme-sky/workers/internal/db"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
 // Service Task raised by ACMESky Interests Manager lame every 1 hour.
 // Get available flights info from the database and create journeys.
 // by "Activity_Foreach_Journey".
-func STCreateJourneys(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+func STCreateJourneys(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
 	variables, err := job.GetVariablesAsMap()
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		return nil, err
 	}
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var available_flights []models.AvailableFlight
 
 	if found := db.Where("departure_time::date >= now()::date AND offer_sent = false").Preload("User").Preload("Interest").Find(&available_flights); found == nil {
-		log.Errorf("[%s] [%d] Interests not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Interests not found")
+		return nil, errors.New("Interests not found")
 	}
 
 	interests := make(map[int][]models.AvailableFlight)
@@ -49,22 +46,21 @@ func STCreateJourneys(client worker.JobClient, job entities.Job) {
 			input, err := models.ValidateJourney(db, in)
 
 			if err != nil {
-				log.Errorf("[%s] [%d] Error creating journey: %s", job.Type, jobKey, err.Error())
-				acmejob.FailJob(client, job)
-				return
+				logger.Error("Error creating journey", "err", err)
+				return nil, acmejob.Fatal(err)
 			}
 
 			var journey models.Journey
 			if found := db.Where("flight1_id = ? AND cost = ? AND user_id = ?", input.Flight1Id, input.Cost, input.UserId).First(&journey).Error; found == nil {
-				log.Warnf("[%s] [%d] Skip an already saved journey", job.Type, jobKey)
+				logger.Warn("Skip an already saved journey")
 				continue
 			}
 
 			journey = models.NewJourney(*input)
 			if err := db.Create(&journey).Error; err != nil {
-				log.Errorf("[%s] [%d] Journey not saved: %s", job.Type, jobKey, err.Error())
+				logger.Error("Journey not saved", "err", err)
 			} else {
-				log.Infof("[%s] [%d] Journey saved", job.Type, jobKey)
+				logger.Info("Journey saved")
 				journeys = append(journeys, journey.Id)
 			}
 		}
@@ -91,43 +87,26 @@ func STCreateJourneys(client worker.JobClient, job entities.Job) {
 		input, err := models.ValidateJourney(db, in)
 
 		if err != nil {
-			log.Errorf("[%s] [%d] Error creating journey: %s", job.Type, jobKey, err.Error())
-			acmejob.FailJob(client, job)
-			return
+			logger.Error("Error creating journey", "err", err)
+			return nil, acmejob.Fatal(err)
 		}
 
 		var journey models.Journey
 		if found := db.Where("flight1_id = ? AND flight2_id = ? AND cost = ? AND user_id = ?", input.Flight1Id, input.Flight2Id, input.Cost, input.UserId).First(&journey).Error; found == nil {
-			log.Warnf("[%s] [%d] Skip an already saved journey", job.Type, jobKey)
+			logger.Warn("Skip an already saved journey")
 			continue
 		}
 
 		journey = models.NewJourney(*input)
 		if err := db.Create(&journey).Error; err != nil {
-			log.Errorf("[%s] [%d] Journey not saved: %s", job.Type, jobKey, err.Error())
+			logger.Error("Journey not saved", "err", err)
 		} else {
-			log.Infof("[%s] [%d] Journey saved", job.Type, jobKey)
+			logger.Info("Journey saved")
 			journeys = append(journeys, journey.Id)
 		}
 	}
 
 	variables["journeys"] = journeys
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-
-	acmejob.JobVariables[job.Type] <- variables
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return variables, nil
 }
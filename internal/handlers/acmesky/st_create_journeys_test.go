@@ -0,0 +1,82 @@
+package handlers_test
+
+import (
+	"testing"
+
+	handlers "github.com/acme-sky/workers/internal/handlers/acmesky"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+	"github.com/acme-sky/workers/internal/models"
+	"gorm.io/gorm"
+)
+
+var createJourneys = acmejob.Job{Name: "ST_Create_Journeys", Handler: handlers.STCreateJourneys}
+
+// Returns the journeys of the `journeys` variable which belong to `user`
+func journeysOf(t *testing.T, conn *gorm.DB, variables map[string]interface{}, user models.User) []models.Journey {
+	t.Helper()
+
+	values, ok := variables["journeys"].([]interface{})
+	if !ok {
+		t.Fatalf("journeys = %#v, want a list", variables["journeys"])
+	}
+
+	ids := make([]uint, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, uint(value.(float64)))
+	}
+
+	var journeys []models.Journey
+	if err := conn.Where("id IN ? AND user_id = ?", ids, user.ID).Order("id").Find(&journeys).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return journeys
+}
+
+func TestSTCreateJourneys(t *testing.T) {
+	conn := testDb(t)
+	user := createUser(t, conn)
+	interest := createInterest(t, conn, user)
+	interestId := int(interest.Id)
+
+	single := createFlight(t, conn, user, nil, 3)
+	outbound := createFlight(t, conn, user, &interestId, 7)
+	inbound := createFlight(t, conn, user, &interestId, 14)
+	createFlight(t, conn, user, nil, -3)
+
+	activated := jobtest.NewJob("ST_Create_Journeys").Build()
+	client := jobtest.Execute(t, createJourneys, activated)
+
+	journeys := journeysOf(t, conn, jobtest.AssertCompleted(t, client, activated), user)
+	if len(journeys) != 2 {
+		t.Fatalf("created %d journeys for the user, want 2: %+v", len(journeys), journeys)
+	}
+
+	if journeys[0].Flight1Id != int(single.Id) || journeys[0].Flight2Id != nil || journeys[0].Cost != single.Cost {
+		t.Errorf("one-way journey = %+v, want flight %d only", journeys[0], single.Id)
+	}
+
+	flights := map[int]bool{journeys[1].Flight1Id: true}
+	if journeys[1].Flight2Id != nil {
+		flights[*journeys[1].Flight2Id] = true
+	}
+	if !flights[int(outbound.Id)] || !flights[int(inbound.Id)] || journeys[1].Cost != outbound.Cost+inbound.Cost {
+		t.Errorf("round trip = %+v, want flights %d and %d", journeys[1], outbound.Id, inbound.Id)
+	}
+}
+
+func TestSTCreateJourneysSkipsSavedJourneys(t *testing.T) {
+	conn := testDb(t)
+	user := createUser(t, conn)
+	createFlight(t, conn, user, nil, 3)
+
+	first := jobtest.NewJob("ST_Create_Journeys").Build()
+	journeysOf(t, conn, jobtest.AssertCompleted(t, jobtest.Execute(t, createJourneys, first), first), user)
+
+	second := jobtest.NewJob("ST_Create_Journeys").Build()
+	variables := jobtest.AssertCompleted(t, jobtest.Execute(t, createJourneys, second), second)
+	if journeys := journeysOf(t, conn, variables, user); len(journeys) != 0 {
+		t.Errorf("created %d journeys again, want none", len(journeys))
+	}
+}
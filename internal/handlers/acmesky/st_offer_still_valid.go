@@ -3,51 +3,32 @@ package handlers
 import (
 	"context"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/db"
-	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
 // Service Task used like a rewind after an error during the "book journey"
 // process.
 // Changes the is_used status of the offer to false.
-func STOfferStillValid(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+func STOfferStillValid(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
 	variables, err := job.GetVariablesAsMap()
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		return nil, err
 	}
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 
 	var offer models.Offer
 	if err := db.Where("id = ?", variables["offer_id"]).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Offer not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Offer not found")
+		return nil, err
 	}
 
-	log.Debug("Processing data:", variables)
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+	logging.FromContext(ctx).Debug("Processing data", "variables", logging.RedactVariables(variables))
 
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return variables, nil
 }
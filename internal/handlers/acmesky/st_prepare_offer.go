@@ -2,40 +2,52 @@ package handlers
 
 import (
 	"context"
-
-	"github.com/charmbracelet/log"
+	"fmt"
 
 	"github.com/acme-sky/workers/internal/db"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/message"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `STPrepareOffer`
+type PrepareOfferInput struct {
+	// Ids of the journeys created by "Create journeys"
+	Journeys []uint `json:"journeys" binding:"required"`
+
+	// Index of the loop, starting from 1
+	LoopCounter int `json:"loopCounter" binding:"required"`
+}
+
+// Variables set by `STPrepareOffer`
+type PrepareOfferOutput struct {
+	Offer models.Offer `json:"offer"`
+}
+
 // Service Task raised by ACMESky Interests Manager lame in a sequential loop
 // for available flights.
 // Create a new offer from an available flight and then send the offer via
 // Prontogram.
-func STPrepareOffer(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+func STPrepareOffer(ctx context.Context, job entities.Job, in PrepareOfferInput) (*PrepareOfferOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+	index := in.LoopCounter - 1
+	if index < 0 || index >= len(in.Journeys) {
+		logger.Error("Index out of range", "index", index)
+		return nil, acmejob.Fatal(fmt.Errorf("Index out of range %d", index))
 	}
 
-	journeys := variables["journeys"].([]interface{})
-	index := int(variables["loopCounter"].(float64)) - 1
-
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var journey models.Journey
 
-	if err := db.Where("id = ?", int(journeys[index].(float64))).Preload("Flight1").Preload("Flight2").Preload("User").First(&journey).Error; err != nil {
-		log.Errorf("[%s] [%d] Journey not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("id = ?", in.Journeys[index]).Preload("Flight1").Preload("Flight2").Preload("User").First(&journey).Error; err != nil {
+		logger.Error("Journey not found")
+		return nil, err
 	}
+	logger = logger.With("user_id", journey.UserId)
 
 	body := models.OfferInput{
 		Flight1: models.OfferInputFields{
@@ -62,55 +74,44 @@ func STPrepareOffer(client worker.JobClient, job entities.Job) {
 
 	offer := models.NewOffer(body)
 
-	if created := db.Create(&offer); created == nil {
-		log.Errorf("[%s] [%d] Offer not saved", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
-	} else {
-		log.Infof("[%s] [%d] Offer saved", job.Type, jobKey)
+	if err := db.Create(&offer).Error; err != nil {
+		logger.Error("Offer not saved", "err", err)
+		return nil, err
+	}
+
+	logger = logger.With("offer_id", offer.Id)
+	logger.Info("Offer saved")
+	metrics.OffersCreated.Inc()
+	message.Emit(ctx, message.JobKey(job), message.EventOfferCreated, map[string]interface{}{
+		"offer_id":   offer.Id,
+		"user_id":    offer.UserId,
+		"journey_id": offer.JourneyId,
+		"expired":    offer.Expired,
+	})
+	var flightInstance models.AvailableFlight
+	if err := db.Where("id = ?", journey.Flight1Id).First(&flightInstance).Error; err != nil {
+		logger.Error("Error on getting flight", "err", err)
+	}
+	flightInstance.OfferSent = true
+	if err := db.Save(&flightInstance).Error; err != nil {
+		logger.Error("Error on saving flight", "err", err)
+	}
+
+	if journey.Flight2 != nil {
 		var flightInstance models.AvailableFlight
-		if err := db.Where("id = ?", journey.Flight1Id).First(&flightInstance).Error; err != nil {
-			log.Errorf("[%s] [%d] Error on getting flight %s", job.Type, jobKey, err.Error())
+		if err := db.Where("id = ?", journey.Flight2Id).First(&flightInstance).Error; err != nil {
+			logger.Error("Error on getting flight", "err", err)
 		}
 		flightInstance.OfferSent = true
 		if err := db.Save(&flightInstance).Error; err != nil {
-			log.Errorf("[%s] [%d] Error on saving flight %s", job.Type, jobKey, err.Error())
-		}
-
-		if journey.Flight2 != nil {
-			var flightInstance models.AvailableFlight
-			if err := db.Where("id = ?", journey.Flight2Id).First(&flightInstance).Error; err != nil {
-				log.Errorf("[%s] [%d] Error on getting flight %s", job.Type, jobKey, err.Error())
-			}
-			flightInstance.OfferSent = true
-			if err := db.Save(&flightInstance).Error; err != nil {
-				log.Errorf("[%s] [%d] Error on saving flight %s", job.Type, jobKey, err.Error())
-			}
+			logger.Error("Error on saving flight", "err", err)
 		}
 	}
 
 	// Preload user info
 	if err := db.Where("id = ?", offer.Id).Preload("User").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Offer not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Offer not found")
+		return nil, err
 	}
-	variables["offer"] = offer
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &PrepareOfferOutput{Offer: offer}, nil
 }
@@ -0,0 +1,73 @@
+package handlers_test
+
+import (
+	"testing"
+
+	handlers "github.com/acme-sky/workers/internal/handlers/acmesky"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+	"github.com/acme-sky/workers/internal/models"
+)
+
+var prepareOffer = acmejob.Job{Name: "ST_Prepare_Offer", Handler: acmejob.Typed(handlers.STPrepareOffer), Retry: &acmejob.NoRetryPolicy}
+
+func TestSTPrepareOffer(t *testing.T) {
+	conn := testDb(t)
+	user := createUser(t, conn)
+	outbound := createFlight(t, conn, user, nil, 7)
+	inbound := createFlight(t, conn, user, nil, 14)
+	journey := createJourney(t, conn, user, outbound, &inbound)
+
+	activated := jobtest.NewJob("ST_Prepare_Offer").Variables(map[string]interface{}{
+		"journeys":    []uint{journey.Id},
+		"loopCounter": 1,
+	}).Build()
+	client := jobtest.Execute(t, prepareOffer, activated)
+
+	variables := jobtest.AssertCompleted(t, client, activated)
+	offer, ok := variables["offer"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("offer = %#v, want an object", variables["offer"])
+	}
+	if token, _ := offer["token"].(string); len(token) != 6 {
+		t.Errorf("offer token = %q, want 6 characters", token)
+	}
+	jobtest.AssertVariable(t, offer, "is_used", false)
+
+	var saved models.Offer
+	if err := conn.Where("id = ?", uint(offer["id"].(float64))).First(&saved).Error; err != nil {
+		t.Fatalf("offer not saved: %s", err.Error())
+	}
+	if saved.JourneyId != int(journey.Id) || saved.UserId != int(user.ID) {
+		t.Errorf("saved offer = %+v, want journey %d of user %d", saved, journey.Id, user.ID)
+	}
+
+	for _, flight := range []models.AvailableFlight{outbound, inbound} {
+		if err := conn.First(&flight, flight.Id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !flight.OfferSent {
+			t.Errorf("flight %d is not marked as sent", flight.Id)
+		}
+	}
+}
+
+func TestSTPrepareOfferIndexOutOfRange(t *testing.T) {
+	activated := jobtest.NewJob("ST_Prepare_Offer").Variables(map[string]interface{}{
+		"journeys":    []uint{1},
+		"loopCounter": 2,
+	}).Build()
+	client := jobtest.Execute(t, prepareOffer, activated)
+
+	jobtest.AssertFailed(t, client, activated, 0)
+}
+
+func TestSTPrepareOfferMissingVariables(t *testing.T) {
+	activated := jobtest.NewJob("ST_Prepare_Offer").Variable("loopCounter", 1).Build()
+	client := jobtest.Execute(t, prepareOffer, activated)
+
+	command := jobtest.AssertFailed(t, client, activated, 0)
+	if command.RetryBackoff != 0 {
+		t.Errorf("backoff = %s, want none", command.RetryBackoff)
+	}
+}
@@ -2,51 +2,40 @@ package handlers
 
 import (
 	"context"
-
-	"github.com/charmbracelet/log"
+	"fmt"
 
 	"github.com/acme-sky/workers/internal/db"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-// Service Task raised by ACMESky when an user sends an offer token.
-// It Checks if the offer is valid for this `token` variable.
-func STRetrieveOffer(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+// Variables used by `STRetrieveOffer`
+type RetrieveOfferInput struct {
+	// Offer token sent by the user
+	Token string `json:"token" binding:"required"`
+}
 
-	db, _ := db.GetDb()
+// Variables set by `STRetrieveOffer`
+type RetrieveOfferOutput struct {
+	OfferId uint `json:"offer_id"`
+}
 
-	var offer models.Offer
+// Service Task raised by ACMESky when an user sends an offer token.
+// It Checks if the offer is valid for this `token` variable, otherwise it throws
+// an `EB_Check_Offer` error.
+func STRetrieveOffer(ctx context.Context, job entities.Job, in RetrieveOfferInput) (*RetrieveOfferOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	if err := db.Where("token = ? AND is_used = 'f' AND to_timestamp(expired::double precision) >= current_timestamp", variables["token"]).First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Token `%s` is not valid", job.Type, jobKey, variables["token"])
-		variables["offer_id"] = nil
-	} else {
-		variables["offer_id"] = offer.Id
-	}
+	db, _ := db.WithContext(ctx)
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+	var offer models.Offer
 
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("token = ? AND is_used = 'f' AND to_timestamp(expired::double precision) >= current_timestamp", in.Token).First(&offer).Error; err != nil {
+		logger.Error("Token is not valid", "token", in.Token)
+		return nil, acmejob.NewBPMNError("EB_Check_Offer", fmt.Sprintf("Token `%s` is not valid", in.Token), map[string]interface{}{"offer_id": nil})
 	}
 
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &RetrieveOfferOutput{OfferId: offer.Id}, nil
 }
@@ -0,0 +1,54 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	handlers "github.com/acme-sky/workers/internal/handlers/acmesky"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+)
+
+var retrieveOffer = acmejob.Job{Name: "ST_Retrieve_Offer", Handler: acmejob.Typed(handlers.STRetrieveOffer)}
+
+func TestSTRetrieveOffer(t *testing.T) {
+	conn := testDb(t)
+	user := createUser(t, conn)
+	journey := createJourney(t, conn, user, createFlight(t, conn, user, nil, 7), nil)
+	offer := createOffer(t, conn, user, journey, "VALID1", time.Hour)
+
+	activated := jobtest.NewJob("ST_Retrieve_Offer").Variable("token", "VALID1").Build()
+	client := jobtest.Execute(t, retrieveOffer, activated)
+
+	variables := jobtest.AssertCompleted(t, client, activated)
+	jobtest.AssertVariable(t, variables, "offer_id", offer.Id)
+}
+
+func TestSTRetrieveOfferMissingToken(t *testing.T) {
+	activated := jobtest.NewJob("ST_Retrieve_Offer").Variable("offer_id", 1).Build()
+	client := jobtest.Execute(t, retrieveOffer, activated)
+
+	jobtest.AssertFailed(t, client, activated, 0)
+}
+
+func TestSTRetrieveOfferInvalidToken(t *testing.T) {
+	conn := testDb(t)
+	user := createUser(t, conn)
+	journey := createJourney(t, conn, user, createFlight(t, conn, user, nil, 7), nil)
+
+	expired := createOffer(t, conn, user, journey, "EXPIRD", -time.Hour)
+	used := createOffer(t, conn, user, journey, "USED01", time.Hour)
+	if err := conn.Model(&used).Update("is_used", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"UNKNWN", expired.Token, used.Token} {
+		t.Run(token, func(t *testing.T) {
+			activated := jobtest.NewJob("ST_Retrieve_Offer").Variable("token", token).Build()
+			client := jobtest.Execute(t, retrieveOffer, activated)
+
+			command := jobtest.AssertThrown(t, client, activated, "EB_Check_Offer")
+			jobtest.AssertVariable(t, command.Variables, "offer_id", nil)
+		})
+	}
+}
@@ -3,74 +3,61 @@ package handlers
 import (
 	"context"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/db"
-	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `STSaveFlightsAsAvailable`
+type SaveFlightsAsAvailableInput struct {
+	// Flights found on an airline, with the airports as objects
+	Flights []map[string]interface{} `json:"flights" binding:"required"`
+}
+
 // Service Task executed on "Activity_Foreach_AirlineService" loop in a case of
 // "Any flight found?" = "Yes".
 // It iterates all flights and save 'em as available.
-func STSaveFlightsAsAvailable(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	db, _ := db.GetDb()
+func STSaveFlightsAsAvailable(ctx context.Context, job entities.Job, in SaveFlightsAsAvailableInput) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
-	flights := variables["flights"].([]interface{})
+	db, _ := db.WithContext(ctx)
 
-	for i := 0; i < len(flights); i++ {
-		flight := flights[i].(map[string]interface{})
-		departure_airport := flight["departure_airport"].(map[string]interface{})
+	for _, flight := range in.Flights {
+		departure_airport, ok := flight["departure_airport"].(map[string]interface{})
+		if !ok {
+			logger.Error("Flight without a departure airport", "code", flight["code"])
+			continue
+		}
 		flight["departure_airport"] = departure_airport["code"]
-		arrival_airport := flight["arrival_airport"].(map[string]interface{})
+		arrival_airport, ok := flight["arrival_airport"].(map[string]interface{})
+		if !ok {
+			logger.Error("Flight without an arrival airport", "code", flight["code"])
+			continue
+		}
 		flight["arrival_airport"] = arrival_airport["code"]
 		input, err := models.ValidateAvailableFlight(db, flight)
 
 		if err != nil {
-			log.Errorf("[%s] [%d] Error validating flight: %s", job.Type, jobKey, err.Error())
+			logger.Error("Error validating flight", "err", err)
 			continue
 		}
 
 		var available_flight models.AvailableFlight
 		if found := db.Where("code = ? AND cost = ? AND departure_airport = ? AND arrival_airport = ? AND departure_time = ? AND arrival_time = ?",
 			input.Code, input.Cost, input.DepartureAirport, input.ArrivalAirport, input.DepartureTime, input.ArrivalTime).First(&available_flight).Error; found == nil {
-			log.Warnf("[%s] [%d] Skip an already saved flight", job.Type, jobKey)
+			logger.Warn("Skip an already saved flight")
 			continue
 		}
 
 		new_available_flight := models.NewAvailableFlight(*input)
 
-		if created := db.Create(&new_available_flight); created == nil {
-			log.Errorf("[%s] [%d] Available flight not saved", job.Type, jobKey)
+		if err := db.Create(&new_available_flight).Error; err != nil {
+			logger.Error("Available flight not saved", "err", err)
 		} else {
-			log.Infof("[%s] [%d] Available flight saved", job.Type, jobKey)
+			logger.Info("Available flight saved")
 		}
 	}
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return nil, nil
 }
@@ -3,31 +3,29 @@ package handlers
 import (
 	"context"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/db"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `STSaveLastMinuteOffer`
+type SaveLastMinuteOfferInput struct {
+	// Flight sent by the airline
+	Flight map[string]interface{} `json:"flight" binding:"required"`
+}
+
 // Service Task raised when an airline sends a "last minute" offer. It creates
 // an available flight to every user.
-func STSaveLastMinuteOffer(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+func STSaveLastMinuteOffer(ctx context.Context, job entities.Job, in SaveLastMinuteOfferInput) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 
 	var users []models.User
 
-	flight := variables["flight"].(map[string]interface{})
+	flight := in.Flight
 
 	db.Find(&users)
 
@@ -38,15 +36,14 @@ func STSaveLastMinuteOffer(client worker.JobClient, job entities.Job) {
 		input, err := models.ValidateAvailableFlight(db, flight)
 
 		if err != nil {
-			log.Errorf("[%s] [%d] Error validating flight: %s", job.Type, jobKey, err.Error())
-			acmejob.FailJob(client, job)
-			return
+			logger.Error("Error validating flight", "err", err)
+			return nil, acmejob.Fatal(err)
 		}
 
 		var available_flight models.AvailableFlight
 		if found := db.Where("code = ? AND cost = ? AND departure_airport = ? AND arrival_airport = ? AND departure_time = ? AND arrival_time = ? AND user_id = ?",
 			input.Code, input.Cost, input.DepartureAirport, input.ArrivalAirport, input.DepartureTime, input.ArrivalTime, input.UserId).First(&available_flight).Error; found == nil {
-			log.Warnf("[%s] [%d] Skip an already saved flight", job.Type, jobKey)
+			logger.Warn("Skip an already saved flight")
 			countNotSaved++
 			continue
 		}
@@ -54,29 +51,15 @@ func STSaveLastMinuteOffer(client worker.JobClient, job entities.Job) {
 		new_available_flight := models.NewAvailableFlight(*input)
 
 		if err := db.Create(&new_available_flight).Error; err != nil {
-			log.Errorf("[%s] [%d] Available flight not saved: %s", job.Type, jobKey, err.Error())
+			logger.Error("Available flight not saved", "err", err)
 			countNotSaved++
 		} else {
-			log.Infof("[%s] [%d] Available flight saved", job.Type, jobKey)
+			logger.Info("Available flight saved")
 			countSaved++
 		}
 	}
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	log.Infof("[%s] [%d] Created %d available flights and %d ignored", job.Type, jobKey, countSaved, countNotSaved)
+	logger.Info("Saved available flights", "saved", countSaved, "ignored", countNotSaved)
 
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return nil, nil
 }
@@ -2,81 +2,86 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"sort"
 	"time"
 
-	"github.com/charmbracelet/log"
-
 	pb "github.com/acme-sky/geodistance-api/pkg/distance/proto"
 	"github.com/acme-sky/workers/internal/config"
 	"github.com/acme-sky/workers/internal/db"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/acme-sky/workers/internal/models"
+	"github.com/acme-sky/workers/internal/tracing"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// Variables used by `STSortRentServices`
+type SortRentServicesInput struct {
+	OfferId uint `json:"offer_id" binding:"required"`
+}
+
+// Distance in meters between the user and a rent company
+type RentDistance struct {
+	Id       uint
+	Distance int
+}
+
+// Variables set by `STSortRentServices`
+type SortRentServicesOutput struct {
+	RentCompanies []RentDistance `json:"rent_companies"`
+	RentStatus    string         `json:"rent_status"`
+}
+
 // Service task used to sort all rent services with key the distance between
 // user and rent geolocalizations.
-func STSortRentServices(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+func STSortRentServices(ctx context.Context, job entities.Job, in SortRentServicesInput) (*SortRentServicesOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var offer models.Offer
 
-	if err := db.Where("id = ?", int(variables["offer_id"].(float64))).Preload("User").Preload("Journey").Preload("Journey.Flight1").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Error on getting offer %s", job.Type, jobKey, err.Error())
+	if err := db.Where("id = ?", in.OfferId).Preload("User").Preload("Journey").Preload("Journey.Flight1").First(&offer).Error; err != nil {
+		logger.Error("Error on getting offer", "err", err)
+		return nil, err
 	}
 
 	if offer.User.Address == nil {
-		log.Warnf("[%s] [%d] User does not have an address", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+		logger.Warn("User does not have an address")
+		return nil, acmejob.Fatal(errors.New("User does not have an address"))
 	}
 
 	conf, _ := config.GetConfig()
 
-	conn, err := grpc.Dial(conf.String("geodistance.api"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(conf.String("geodistance.api"), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(), metrics.UnaryClientInterceptor("geodistance")))
 	if err != nil {
-		log.Errorf("[%s] [%d] Can't connect to Geodistance url: %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Can't connect to Geodistance url", "err", err)
+		return nil, err
 	}
 
 	defer conn.Close()
 	c := pb.NewDistanceClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 
 	userGeometry, err := c.FindGeometry(ctx, &pb.AddressRequest{
 		Address: *offer.User.Address,
 	})
 	if err != nil {
-		log.Errorf("[%s] [%d] Can't find geometry for user: %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Can't find geometry for user", "err", err)
+		return nil, err
 	}
 
 	var rents []models.Rent
 	if err := db.Find(&rents).Error; err != nil {
-		log.Errorf("[%s] [%d] Rents not found %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Rents not found", "err", err)
+		return nil, err
 	}
 
-	type RentDistance struct {
-		Id       uint
-		Distance int
-	}
 	var distances []RentDistance
 
 	for _, rent := range rents {
@@ -85,7 +90,7 @@ func STSortRentServices(client worker.JobClient, job entities.Job) {
 			Destination: &pb.MapPosition{Latitude: rent.Latitude, Longitude: rent.Longitude},
 		})
 		if err != nil {
-			log.Warnf("[%s] [%d] Can't find distance for %s: %s", job.Type, jobKey, rent.Name, err.Error())
+			logger.Warn("Can't find distance for the rent", "rent", rent.Name, "err", err)
 			distances = append(distances, RentDistance{Id: rent.Id, Distance: 999999})
 			continue
 		}
@@ -93,33 +98,13 @@ func STSortRentServices(client worker.JobClient, job entities.Job) {
 	}
 
 	if len(rents) == 0 {
-		log.Errorf("[%s] [%d] There is no available rent company: %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("There is no available rent company", "err", err)
+		return nil, acmejob.Fatal(errors.New("There is no available rent company"))
 	}
 
 	sort.Slice(distances, func(i, j int) bool {
 		return distances[i].Distance > distances[j].Distance
 	})
 
-	variables["rent_companies"] = distances
-	variables["rent_status"] = "No"
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx = context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &SortRentServicesOutput{RentCompanies: distances, RentStatus: "No"}, nil
 }
@@ -2,93 +2,84 @@ package handlers
 
 import (
 	"context"
-
-	"github.com/charmbracelet/log"
+	"fmt"
 
 	"github.com/acme-sky/workers/internal/db"
 	"github.com/acme-sky/workers/internal/http"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/message"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-// Task used to create a new rent for an offer
-func TMAskForRent(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+// Variables used by `TMAskForRent`
+type AskForRentInput struct {
+	// Rent companies sorted by "Sort the nearest rent services"
+	RentCompanies []RentDistance `json:"rent_companies" binding:"required"`
 
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+	// Index of the loop, starting from 1
+	LoopCounter int `json:"loopCounter" binding:"required"`
 
-	db, _ := db.GetDb()
-	var rent models.Rent
+	OfferId uint `json:"offer_id" binding:"required"`
+}
 
-	rentCompanies := variables["rent_companies"].([]interface{})
-	index := int(variables["loopCounter"].(float64)) - 1
+// Variables set by `TMAskForRent`
+type AskForRentOutput struct {
+	// `Ok` if the rent is booked, otherwise it's not changed
+	RentStatus string `json:"rent_status,omitempty"`
+}
 
-	if len(rentCompanies) == 0 {
-		log.Infof("[%s] [%d] You must define a rent_company object", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+// Task used to create a new rent for an offer
+func TMAskForRent(ctx context.Context, job entities.Job, in AskForRentInput) (*AskForRentOutput, error) {
+	logger := logging.FromContext(ctx)
+
+	db, _ := db.WithContext(ctx)
+	var rent models.Rent
+
+	index := in.LoopCounter - 1
+	if index < 0 || index >= len(in.RentCompanies) {
+		logger.Error("Index out of range", "index", index)
+		return nil, acmejob.Fatal(fmt.Errorf("Index out of range %d", index))
 	}
-	rentCompany := rentCompanies[index].(map[string]interface{})
-	rentCompanyId := int(rentCompany["Id"].(float64))
 
-	if err := db.Where("id = ?", rentCompanyId).First(&rent).Error; err != nil {
-		log.Errorf("[%s] [%d] Rent not found %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("id = ?", in.RentCompanies[index].Id).First(&rent).Error; err != nil {
+		logger.Error("Rent not found", "err", err)
+		return nil, err
 	}
 
 	var offer models.Offer
-	if err := db.Where("id = ?", variables["offer_id"]).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Journey not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("id = ?", in.OfferId).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
+		logger.Error("Journey not found")
+		return nil, err
 	}
 
-	response, err := http.MakeRentRequest(rent, offer)
+	out := &AskForRentOutput{}
+	response, err := http.MakeRentRequest(ctx, rent, offer)
 
 	if err != nil {
-		log.Errorf("[%s] [%d] Error for rent `%s`: %s", job.Type, jobKey, rent.Name, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error for the rent", "rent", rent.Name, "err", err)
+		return nil, err
 	} else {
 		if response.Status == "OK" {
-			variables["rent_status"] = "Ok"
+			out.RentStatus = "Ok"
 			offer.RentEndpoint = rent.Endpoint
 			offer.RentId = response.RentId
 			if err := db.Save(&offer).Error; err != nil {
-				log.Errorf("[%s] [%d] Error on saving offer %s", job.Type, jobKey, err.Error())
-				acmejob.FailJob(client, job)
-				return
+				logger.Error("Error on saving offer", "err", err)
+				return nil, err
 			}
-			log.Infof("[%s] [%d] Rent `%s` is OK with ID `%s`", job.Type, jobKey, rent.Name, response.RentId)
+			logger.Info("Rent is OK", "rent", rent.Name, "rent_id", response.RentId)
+			message.Emit(ctx, message.JobKey(job), message.EventRentBooked, map[string]interface{}{
+				"offer_id": offer.Id,
+				"user_id":  offer.UserId,
+				"rent":     rent.Name,
+				"rent_id":  response.RentId,
+			})
 		} else {
-			log.Errorf("[%s] [%d] Rent `%s` is not OK", job.Type, jobKey, rent.Name)
+			logger.Error("Rent is not OK", "rent", rent.Name)
 		}
 	}
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Debug("Processing data:", variables)
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return out, nil
 }
@@ -4,42 +4,46 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/config"
 	"github.com/acme-sky/workers/internal/db"
 	"github.com/acme-sky/workers/internal/http"
-	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
+	"github.com/acme-sky/workers/internal/webhook"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-// Task who creates a new payment link for an offer.
-func TMAskPaymentLink(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+// Variables used by `TMAskPaymentLink`
+type AskPaymentLinkInput struct {
+	OfferId uint `json:"offer_id" binding:"required"`
+}
 
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+// Variables set by `TMAskPaymentLink`
+type AskPaymentLinkOutput struct {
+	PaymentLink string `json:"payment_link"`
+
+	// Cost of the journey to pay
+	FlightPrice float64 `json:"flight_price"`
+}
+
+// Task who creates a new payment link for an offer.
+func TMAskPaymentLink(ctx context.Context, job entities.Job, in AskPaymentLinkInput) (*AskPaymentLinkOutput, error) {
+	logger := logging.FromContext(ctx)
 
 	conf, _ := config.GetConfig()
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var offer models.Offer
-	if err := db.Where("id = ?", variables["offer_id"]).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Offer not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("id = ?", in.OfferId).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
+		logger.Error("Offer not found")
+		return nil, err
 	}
 
 	endpoint := fmt.Sprintf("%s/payments/", conf.String("bank.endpoint"))
 	payload := map[string]interface{}{
 		"owner":    fmt.Sprintf("%s <%s>", offer.User.Name, offer.User.Email),
 		"amount":   offer.Journey.Cost,
-		"callback": fmt.Sprintf("%s/%d/", conf.String("bank.callback"), offer.Id),
+		"callback": webhook.BankCallback(conf.String("bank.callback"), conf.String("webhook.bank.secret"), offer.Id),
 	}
 
 	if offer.Journey.Flight2 != nil {
@@ -54,39 +58,18 @@ func TMAskPaymentLink(client worker.JobClient, job entities.Job) {
 			offer.Journey.Flight1.ArrivalAirport)
 	}
 
-	response, err := http.NewPaymentRequest(endpoint, payload, conf.String("bank.token"))
+	response, err := http.NewPaymentRequest(ctx, endpoint, payload, conf.String("bank.token"))
 
 	if err != nil {
-		log.Errorf("[%s] [%d] Error for offer `%d`: %s", job.Type, jobKey, offer.Id, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error for the offer", "err", err)
+		return nil, err
 	}
 
-	variables["payment_link"] = fmt.Sprintf("%s%s", conf.String("bank.payment.endpoint"), response.Id)
-	variables["flight_price"] = offer.Journey.Cost
-
-	offer.PaymentLink = variables["payment_link"].(string)
+	offer.PaymentLink = fmt.Sprintf("%s%s", conf.String("bank.payment.endpoint"), response.Id)
 	if err := db.Save(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Error on saving offer %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error on saving offer", "err", err)
+		return nil, err
 	}
 
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &AskPaymentLinkOutput{PaymentLink: offer.PaymentLink, FlightPrice: offer.Journey.Cost}, nil
 }
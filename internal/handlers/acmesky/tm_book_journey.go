@@ -4,34 +4,38 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/db"
 	"github.com/acme-sky/workers/internal/http"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/message"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `TMBookJourney`
+type BookJourneyInput struct {
+	OfferId uint `json:"offer_id" binding:"required"`
+}
+
+// Variables set by `TMBookJourney`
+type BookJourneyOutput struct {
+	// Cost of the booked journey
+	FlightPrice float64 `json:"flight_price"`
+}
+
 // Task used to book a journey in an airline company. It first checks if the
 // flight still exists and then, after a login to the airline company, makes the
 // request for saving the journey.
-func TMBookJourney(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+// If a flight does not exist anymore, it throws an `EB_Book_Journey` error.
+func TMBookJourney(ctx context.Context, job entities.Job, in BookJourneyInput) (*BookJourneyOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 	var offer models.Offer
-	if err := db.Where("id = ?", variables["offer_id"]).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
-		log.Errorf("[%s] [%d] Journey not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+	if err := db.Where("id = ?", in.OfferId).Preload("Journey").Preload("Journey.Flight1").Preload("Journey.Flight2").Preload("User").First(&offer).Error; err != nil {
+		logger.Error("Journey not found")
+		return nil, err
 	}
 
 	flight1 := offer.Journey.Flight1
@@ -44,9 +48,8 @@ func TMBookJourney(client worker.JobClient, job entities.Job) {
 
 	var flight1Airline models.Airline
 	if err := db.Where("name = ?", flight1.Airline).First(&flight1Airline).Error; err != nil {
-		log.Errorf("[%s] [%d] Airline not found", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Airline not found")
+		return nil, err
 	}
 
 	endpoint := fmt.Sprintf("%s/flights/filter/", flight1Airline.Endpoint)
@@ -58,34 +61,35 @@ func TMBookJourney(client worker.JobClient, job entities.Job) {
 		"arrival_time":      flight1.ArrivalTime,
 	}
 
-	response, err := http.MakeRequest(endpoint, payload)
+	response, err := http.MakeRequest(ctx, endpoint, payload)
 
 	if err != nil {
-		log.Errorf("[%s] [%d] Error for airline `%s`: %s", job.Type, jobKey, flight1Airline.Endpoint, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error for the airline", "airline_endpoint", flight1Airline.Endpoint, "err", err)
+		return nil, err
 	} else {
 		if response.Count > 0 {
 			if response.Count == 1 {
-				flight1_id = int(response.Data[0]["id"].(float64))
+				id, ok := response.Data[0]["id"].(float64)
+				if !ok {
+					logger.Error("Flight without an id", "flight_id", flight1.Id)
+					return nil, fmt.Errorf("Flight found for flight1 = `%d` has no id", flight1.Id)
+				}
+				flight1_id = int(id)
 			} else {
-				log.Errorf("[%s] [%d] Found `%d` flights for flight1 = `%d`", job.Type, jobKey, response.Count, flight1.Id)
-				acmejob.FailJob(client, job)
-				return
+				logger.Error("Found more than one flight", "count", response.Count, "flight_id", flight1.Id)
+				return nil, acmejob.NewBPMNError("EB_Book_Journey", fmt.Sprintf("Found `%d` flights for flight1 = `%d`", response.Count, flight1.Id), nil)
 			}
 		} else {
-			log.Errorf("[%s] [%d] No flight found for flight1 = `%d`", job.Type, jobKey, flight1.Id)
-			acmejob.FailJob(client, job)
-			return
+			logger.Error("No flight found", "flight_id", flight1.Id)
+			return nil, acmejob.NewBPMNError("EB_Book_Journey", fmt.Sprintf("No flight found for flight1 = `%d`", flight1.Id), nil)
 		}
 	}
 
 	if flight2 != nil {
 		var flight2Airline models.Airline
 		if err := db.Where("name = ?", flight2.Airline).First(&flight2Airline).Error; err != nil {
-			log.Errorf("[%s] [%d] Airline not found", job.Type, jobKey)
-			acmejob.FailJob(client, job)
-			return
+			logger.Error("Airline not found")
+			return nil, err
 		}
 		endpoint := fmt.Sprintf("%s/flights/filter/", flight2Airline.Endpoint)
 		payload := map[string]interface{}{
@@ -96,25 +100,27 @@ func TMBookJourney(client worker.JobClient, job entities.Job) {
 			"arrival_time":      flight2.ArrivalTime,
 		}
 
-		response, err := http.MakeRequest(endpoint, payload)
+		response, err := http.MakeRequest(ctx, endpoint, payload)
 
 		if err != nil {
-			log.Errorf("[%s] [%d] Error for airline `%s`: %s", job.Type, jobKey, flight2Airline.Endpoint, err.Error())
-			acmejob.FailJob(client, job)
-			return
+			logger.Error("Error for the airline", "airline_endpoint", flight2Airline.Endpoint, "err", err)
+			return nil, err
 		} else {
 			if response.Count > 0 {
 				if response.Count == 1 {
-					flight2_id = int(response.Data[0]["id"].(float64))
+					id, ok := response.Data[0]["id"].(float64)
+					if !ok {
+						logger.Error("Flight without an id", "flight_id", flight2.Id)
+						return nil, fmt.Errorf("Flight found for flight2 = `%d` has no id", flight2.Id)
+					}
+					flight2_id = int(id)
 				} else {
-					log.Errorf("[%s] [%d] Found `%d` flights for flight2 = `%d`", job.Type, jobKey, response.Count, flight2.Id)
-					acmejob.FailJob(client, job)
-					return
+					logger.Error("Found more than one flight", "count", response.Count, "flight_id", flight2.Id)
+					return nil, acmejob.NewBPMNError("EB_Book_Journey", fmt.Sprintf("Found `%d` flights for flight2 = `%d`", response.Count, flight2.Id), nil)
 				}
 			} else {
-				log.Errorf("[%s] [%d] No flight found for flight2 = `%d`", job.Type, jobKey, flight2.Id)
-				acmejob.FailJob(client, job)
-				return
+				logger.Error("No flight found", "flight_id", flight2.Id)
+				return nil, acmejob.NewBPMNError("EB_Book_Journey", fmt.Sprintf("No flight found for flight2 = `%d`", flight2.Id), nil)
 			}
 		}
 	}
@@ -125,11 +131,10 @@ func TMBookJourney(client worker.JobClient, job entities.Job) {
 		"password": flight1Airline.LoginPassword,
 	}
 
-	token, err := http.MakeLogin(endpoint, payload)
+	token, err := http.MakeLogin(ctx, endpoint, payload)
 	if err != nil {
-		log.Errorf("[%s] [%d] Can't perform login: %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Can't perform login", "err", err)
+		return nil, err
 	}
 
 	endpoint = fmt.Sprintf("%s/journeys/", flight1Airline.Endpoint)
@@ -143,31 +148,20 @@ func TMBookJourney(client worker.JobClient, job entities.Job) {
 		payload["arrival_flight_id"] = flight2_id
 	}
 
-	journeyResponse, err := http.NewJourneyRequest(endpoint, payload, *token)
-	if err != nil {
-		log.Errorf("[%s] [%d] Can't save new journey: %s", job.Type, jobKey, err.Error())
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	variables["flight_price"] = offer.Journey.Cost
-	log.Infof("[%s] [%d] Created a new new journey on airline company website with ID = %d", job.Type, jobKey, journeyResponse.Id)
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
+	journeyResponse, err := http.NewJourneyRequest(ctx, endpoint, payload, *token)
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Can't save new journey", "err", err)
+		return nil, err
 	}
 
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
+	logger.Info("Created a new journey on the airline website", "airline_journey_id", journeyResponse.Id)
+	message.Emit(ctx, message.JobKey(job), message.EventJourneyBooked, map[string]interface{}{
+		"offer_id":           offer.Id,
+		"user_id":            offer.UserId,
+		"journey_id":         offer.JourneyId,
+		"airline_journey_id": journeyResponse.Id,
+		"cost":               offer.Journey.Cost,
+	})
 
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &BookJourneyOutput{FlightPrice: offer.Journey.Cost}, nil
 }
@@ -2,38 +2,19 @@ package handlers
 
 import (
 	"context"
-	"github.com/charmbracelet/log"
 
-	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-func TMErrorOnCheckOffer(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
+func TMErrorOnCheckOffer(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
 	variables, err := job.GetVariablesAsMap()
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		return nil, err
 	}
 
-	log.Debug("Processing data:", variables)
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
+	logging.FromContext(ctx).Debug("Processing data", "variables", logging.RedactVariables(variables))
 
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return variables, nil
 }
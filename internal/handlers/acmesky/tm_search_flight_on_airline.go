@@ -2,138 +2,113 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-
-	"github.com/charmbracelet/log"
+	"time"
 
 	"github.com/acme-sky/workers/internal/http"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
+// Variables used by `TMSearchFlightsOnAirline`
+type SearchFlightsOnAirlineInput struct {
+	// Airlines returned by "Get user interests"
+	Airlines []models.Airline `json:"airlines" binding:"required"`
+
+	// Interests returned by "Get user interests"
+	Interests []models.Interest `json:"interests"`
+
+	// Index of the loop, starting from 1
+	LoopCounter int `json:"loopCounter" binding:"required"`
+}
+
+// Variables set by `TMSearchFlightsOnAirline`
+type SearchFlightsOnAirlineOutput struct {
+	Flights []map[string]interface{} `json:"flights"`
+}
+
 // Task raised by ACMESky Flights manager lame in a sequential loop by "Get user
 // interests".
 // It makes a filter for airlines and set a variable `flight` is something is
 // found. A request could be:
 // curl -X POST <base>/flights/filter/ -H 'content-type: application/json' -H 'accept: application/json' \
 // -d '{"departure_time":"2024-04-30T04:12:00+02:00","arrival_time":"2024-05-01T11:00:00+02:00","departure_airport":"CPH","arrival_airport":"CTA"}'
-func TMSearchFlightsOnAirline(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+func TMSearchFlightsOnAirline(ctx context.Context, job entities.Job, in SearchFlightsOnAirlineInput) (*SearchFlightsOnAirlineOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+	index := in.LoopCounter - 1
+	if index < 0 || index >= len(in.Airlines) {
+		logger.Error("Index out of range", "index", index)
+		return nil, acmejob.Fatal(fmt.Errorf("Index out of range %d", index))
 	}
 
-	airlines := variables["airlines"].([]interface{})
-	index := int(variables["loopCounter"].(float64)) - 1
-
-	m := airlines[index]
-	jsonData, err := json.Marshal(m)
-	if err != nil {
-		fmt.Println("Error marshaling map to JSON:", err)
-		return
-	}
-
-	var airline models.Airline
-	err = json.Unmarshal(jsonData, &airline)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON to struct:", err)
-		return
-	}
-
-	interests := variables["interests"].([]interface{})
+	airline := in.Airlines[index]
+	interests := in.Interests
 
 	if len(interests) == 0 {
-		log.Warnf("Error for airline `%s`: there is no interest", airline.Name)
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	if index < 0 || index >= len(interests) {
-		log.Errorf("Error for airline `%s`: index out of range %d", airline.Name, index)
-		acmejob.FailJob(client, job)
-		return
+		logger.Warn("There is no interest for the airline", "airline", airline.Name)
+		return nil, acmejob.Fatal(fmt.Errorf("Error for airline `%s`: there is no interest", airline.Name))
 	}
 
 	flights := []map[string]interface{}{}
 	endpoint := fmt.Sprintf("%s/flights/filter/", airline.Endpoint)
 	for i := 0; i < len(interests); i++ {
-		interest := interests[i].(map[string]interface{})
-		interestId := int(interest["id"].(float64))
-		user := interest["user"].(map[string]interface{})
+		interest := interests[i]
 
 		payload := map[string]interface{}{
-			"departure_airport": interest["flight1_departure_airport"].(string),
-			"departure_time":    interest["flight1_departure_time"].(string),
-			"arrival_airport":   interest["flight1_arrival_airport"].(string),
-			"arrival_time":      interest["flight1_arrival_time"].(string),
+			"departure_airport": interest.Flight1DepartureAirport,
+			"departure_time":    interest.Flight1DepartureTime.Format(time.RFC3339),
+			"arrival_airport":   interest.Flight1ArrivalAirport,
+			"arrival_time":      interest.Flight1ArrivalTime.Format(time.RFC3339),
 		}
 
-		response, err := http.MakeRequest(endpoint, payload)
+		response, err := http.MakeRequest(ctx, endpoint, payload)
 
 		if err != nil {
-			log.Errorf("Error for airline `%s`: %s", airline.Name, err.Error())
+			logger.Error("Error for the airline", "airline", airline.Name, "err", err)
 		} else {
 			if response.Count > 0 {
 				for _, data := range response.Data {
-					data["user_id"] = user["ID"]
+					data["user_id"] = interest.User.ID
 					data["airline"] = airline.Name
-					data["interest_id"] = interestId
+					data["interest_id"] = interest.Id
 					flights = append(flights, data)
 				}
 			}
 		}
 
-		if interest["flight2_departure_airport"] == nil {
+		if interest.Flight2DepartureAirport == nil || interest.Flight2ArrivalAirport == nil ||
+			interest.Flight2DepartureTime == nil || interest.Flight2ArrivalTime == nil {
 			continue
 		}
 
 		payload = map[string]interface{}{
-			"departure_airport": interest["flight2_departure_airport"].(string),
-			"departure_time":    interest["flight2_departure_time"].(string),
-			"arrival_airport":   interest["flight2_arrival_airport"].(string),
-			"arrival_time":      interest["flight2_arrival_time"].(string),
+			"departure_airport": *interest.Flight2DepartureAirport,
+			"departure_time":    interest.Flight2DepartureTime.Format(time.RFC3339),
+			"arrival_airport":   *interest.Flight2ArrivalAirport,
+			"arrival_time":      interest.Flight2ArrivalTime.Format(time.RFC3339),
 		}
 
-		response, err = http.MakeRequest(endpoint, payload)
+		response, err = http.MakeRequest(ctx, endpoint, payload)
 
 		if err != nil {
-			log.Errorf("Error for airline `%s`: %s", airline.Name, err.Error())
+			logger.Error("Error for the airline", "airline", airline.Name, "err", err)
 			continue
 		}
 
 		if response.Count > 0 {
 			for _, data := range response.Data {
-				data["user_id"] = user["ID"]
+				data["user_id"] = interest.User.ID
 				data["airline"] = airline.Name
-				data["interest_id"] = interestId
+				data["interest_id"] = interest.Id
 				flights = append(flights, data)
 			}
 		}
 	}
 
-	variables["flights"] = flights
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job with len(flights) = %d", job.Type, jobKey, len(flights))
+	logger.Info("Found flights", "count", len(flights))
 
-	acmejob.JobVariables[job.Type] <- variables
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return &SearchFlightsOnAirlineOutput{Flights: flights}, nil
 }
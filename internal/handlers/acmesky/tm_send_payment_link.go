@@ -2,44 +2,25 @@ package handlers
 
 import (
 	"context"
-	"github.com/charmbracelet/log"
-
+	"errors"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
 // This task sends the payment_link to the user
-func TMSendPaymentLink(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
+func TMSendPaymentLink(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
 	variables, err := job.GetVariablesAsMap()
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		return nil, err
 	}
 
 	if variables["payment_link"] == nil {
-		log.Errorf("[%s] [%d] `payment_link` is a not a valid variable to send", job.Type, jobKey)
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("`payment_link` is a not a valid variable to send")
+		return nil, acmejob.Fatal(errors.New("`payment_link` is a not a valid variable to send"))
 	}
 
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return variables, nil
 }
@@ -2,44 +2,32 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/charmbracelet/log"
-
 	"github.com/acme-sky/workers/internal/config"
 	"github.com/acme-sky/workers/internal/http"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-// Service used to save info into Prontogram backend service.
-func STSaveInfoOnProntogram(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
-	variables, err := job.GetVariablesAsMap()
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	m := variables["offer"].(map[string]interface{})
+// Variables used by `STSaveInfoOnProntogram`
+type SaveInfoOnProntogramInput struct {
+	Offer models.Offer `json:"offer" binding:"required"`
+}
 
-	jsonData, err := json.Marshal(m)
-	if err != nil {
-		fmt.Println("Error marshaling map to JSON:", err)
-		return
-	}
+// Service used to save info into Prontogram backend service.
+func STSaveInfoOnProntogram(ctx context.Context, job entities.Job, in SaveInfoOnProntogramInput) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 
-	var offer models.Offer
-	err = json.Unmarshal(jsonData, &offer)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON to struct:", err)
-		return
+	offer := in.Offer
+	if offer.User.ProntogramUsername == nil {
+		logger.Error("User does not have a Prontogram username")
+		return nil, acmejob.Fatal(errors.New("User does not have a Prontogram username"))
 	}
 
 	conf, _ := config.GetConfig()
@@ -53,28 +41,12 @@ func STSaveInfoOnProntogram(client worker.JobClient, job entities.Job) {
 		Username:   *offer.User.ProntogramUsername,
 		Sid:        " ",
 	}
-	_, err = http.MakeProntogramRequest(endpoint, payload)
-
-	if err != nil {
-		log.Errorf("[%s] [%d] Error for offer `%d`: %s", job.Type, jobKey, offer.Id, err.Error())
-		acmejob.FailJob(client, job)
-		return
-	}
+	_, err := http.MakeProntogramRequest(ctx, endpoint, payload)
 
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		logger.Error("Error for the offer", "err", err)
+		return nil, err
 	}
 
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return nil, nil
 }
@@ -2,37 +2,15 @@ package handlers
 
 import (
 	"context"
-	"github.com/charmbracelet/log"
 
-	acmejob "github.com/acme-sky/workers/internal/job"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 )
 
-func TMPropagateMessageFromProntogram(client worker.JobClient, job entities.Job) {
-	jobKey := job.GetKey()
-
+func TMPropagateMessageFromProntogram(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
 	variables, err := job.GetVariablesAsMap()
 	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	request, err := client.NewCompleteJobCommand().JobKey(jobKey).VariablesFromMap(variables)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
+		return nil, err
 	}
 
-	ctx := context.Background()
-	_, err = request.Send(ctx)
-	if err != nil {
-		acmejob.FailJob(client, job)
-		return
-	}
-
-	log.Infof("[%s] [%d] Successfully completed job", job.Type, jobKey)
-	acmejob.JobVariables[job.Type] <- variables
-
-	acmejob.JobStatuses.Close(job.Type, 0)
+	return variables, nil
 }
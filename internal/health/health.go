@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Function which returns an error if a dependency is not healthy
+type Check func(ctx context.Context) error
+
+// Result of a single check
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Body returned by the health endpoints
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Set of named checks
+type Checker struct {
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]Check
+}
+
+// Returns an empty checker
+func NewChecker() *Checker {
+	return &Checker{checks: map[string]Check{}}
+}
+
+// Add a check with `name`, replacing the one with the same name
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Run all the checks concurrently, each one with `timeout`
+func (c *Checker) Run(ctx context.Context, timeout time.Duration) Report {
+	c.mu.RLock()
+	names := append([]string{}, c.names...)
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	report := Report{Status: "ok", Checks: make(map[string]CheckResult, len(names))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			result := CheckResult{Status: "ok", Duration: time.Since(start).String()}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = "error"
+			}
+			mu.Unlock()
+		}(name, checks[name])
+	}
+	wg.Wait()
+
+	return report
+}
+
+// HTTP handler which runs the checks and returns the report as JSON, with a
+// 503 status if any check fails
+func (c *Checker) Handler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context(), timeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -2,12 +2,16 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/acme-sky/workers/internal/tracing"
 )
 
 type ResponseBody struct {
@@ -64,11 +68,11 @@ type ProntogramMessageResponse struct {
 
 // Make a new request to an endpoint with a `body` and returns a response body
 // or an error.
-func MakeRequest(endpoint string, body map[string]interface{}) (*ResponseBody, error) {
+func MakeRequest(ctx context.Context, endpoint string, body map[string]interface{}) (*ResponseBody, error) {
 	jsonBody, _ := json.Marshal(body)
 	bodyReader := bytes.NewReader(jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bodyReader)
 
 	if err != nil {
 		return nil, err
@@ -78,7 +82,7 @@ func MakeRequest(endpoint string, body map[string]interface{}) (*ResponseBody, e
 		Timeout: 30 * time.Second,
 	}
 
-	res, err := httpClient.Do(req)
+	res, err := do("airline", &httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -101,11 +105,11 @@ func MakeRequest(endpoint string, body map[string]interface{}) (*ResponseBody, e
 }
 
 // Make a login with credentials and returns the auth token
-func MakeLogin(endpoint string, body map[string]interface{}) (*string, error) {
+func MakeLogin(ctx context.Context, endpoint string, body map[string]interface{}) (*string, error) {
 	jsonBody, _ := json.Marshal(body)
 	bodyReader := bytes.NewReader(jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bodyReader)
 
 	if err != nil {
 		return nil, err
@@ -115,7 +119,7 @@ func MakeLogin(endpoint string, body map[string]interface{}) (*string, error) {
 		Timeout: 30 * time.Second,
 	}
 
-	res, err := httpClient.Do(req)
+	res, err := do("airline", &httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -139,11 +143,11 @@ func MakeLogin(endpoint string, body map[string]interface{}) (*string, error) {
 
 // Make a new request to an endpoint with a `body` for a new journey. `auth` is
 // a bearer token.
-func NewJourneyRequest(endpoint string, body map[string]interface{}, auth string) (*JourneyResponseBody, error) {
+func NewJourneyRequest(ctx context.Context, endpoint string, body map[string]interface{}, auth string) (*JourneyResponseBody, error) {
 	jsonBody, _ := json.Marshal(body)
 	bodyReader := bytes.NewReader(jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bodyReader)
 
 	if err != nil {
 		return nil, err
@@ -155,7 +159,7 @@ func NewJourneyRequest(endpoint string, body map[string]interface{}, auth string
 		Timeout: 30 * time.Second,
 	}
 
-	res, err := httpClient.Do(req)
+	res, err := do("airline", &httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -179,11 +183,11 @@ func NewJourneyRequest(endpoint string, body map[string]interface{}, auth string
 
 // Make a new request to an endpoint with a `body` for a new payment bank. `auth` is
 // the API token.
-func NewPaymentRequest(endpoint string, body map[string]interface{}, auth string) (*PaymentResponseBody, error) {
+func NewPaymentRequest(ctx context.Context, endpoint string, body map[string]interface{}, auth string) (*PaymentResponseBody, error) {
 	jsonBody, _ := json.Marshal(body)
 	bodyReader := bytes.NewReader(jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bodyReader)
 
 	if err != nil {
 		return nil, err
@@ -195,7 +199,7 @@ func NewPaymentRequest(endpoint string, body map[string]interface{}, auth string
 		Timeout: 30 * time.Second,
 	}
 
-	res, err := httpClient.Do(req)
+	res, err := do("bank", &httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -218,8 +222,13 @@ func NewPaymentRequest(endpoint string, body map[string]interface{}, auth string
 }
 
 // Make a new request to an endpoint to get info about an airport.
-func GetAirportInfo(endpoint string) (*AirportInfoResponseBody, error) {
-	res, err := http.Get(endpoint)
+func GetAirportInfo(ctx context.Context, endpoint string) (*AirportInfoResponseBody, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := do("airline", http.DefaultClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -242,11 +251,11 @@ func GetAirportInfo(endpoint string) (*AirportInfoResponseBody, error) {
 }
 
 // Make a new request for Prontogram and returns a ProntogramMessageResponse
-func MakeProntogramRequest(endpoint string, body ProntogramMessageRequest) (*ProntogramMessageResponse, error) {
+func MakeProntogramRequest(ctx context.Context, endpoint string, body ProntogramMessageRequest) (*ProntogramMessageResponse, error) {
 	jsonBody, _ := json.Marshal(body)
 	bodyReader := bytes.NewReader(jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bodyReader)
 
 	if err != nil {
 		return nil, err
@@ -258,7 +267,7 @@ func MakeProntogramRequest(endpoint string, body ProntogramMessageRequest) (*Pro
 
 	req.Header.Add("Content-Type", "application/json")
 
-	res, err := httpClient.Do(req)
+	res, err := do("prontogram", &httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -279,3 +288,13 @@ func MakeProntogramRequest(endpoint string, body ProntogramMessageRequest) (*Pro
 
 	return &responseBody, nil
 }
+
+// Send `req` to `dependency` with `client`, tracing it and observing its
+// metrics
+func do(dependency string, client *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := tracing.Do(client, req)
+	metrics.ObserveRequest(dependency, start, metrics.HTTPStatus(res, err))
+
+	return res, err
+}
@@ -1,13 +1,16 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/acme-sky/workers/internal/db"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/acme-sky/workers/internal/models"
-	"github.com/charmbracelet/log"
+	"github.com/acme-sky/workers/internal/tracing"
 	"github.com/tiaguinho/gosoap"
 )
 
@@ -35,17 +38,17 @@ type GetRentByIdResponse struct {
 
 // SOAP call to BookRent action for a selected rent. Returns the call response
 // which has a Status and RentId, the latter will be saved on the offer journey
-func MakeRentRequest(rent models.Rent, offer models.Offer) (*BookRentResponse, error) {
+func MakeRentRequest(ctx context.Context, rent models.Rent, offer models.Offer) (*BookRentResponse, error) {
 	httpClient := &http.Client{
 		Timeout: 1500 * time.Millisecond,
 	}
 	soap, err := gosoap.SoapClient(rent.Endpoint, httpClient)
 	if err != nil {
-		log.Errorf("SoapClient error: %s", err)
+		logging.FromContext(ctx).Error("Failed to create the SOAP client", "err", err)
 		return nil, err
 	}
 
-	db, _ := db.GetDb()
+	db, _ := db.WithContext(ctx)
 
 	var flight1Airline models.Airline
 	if err := db.Where("name = ?", offer.Journey.Flight1.Airline).First(&flight1Airline).Error; err != nil {
@@ -53,9 +56,9 @@ func MakeRentRequest(rent models.Rent, offer models.Offer) (*BookRentResponse, e
 	}
 
 	endpoint := fmt.Sprintf("%s/airports/code/%s/", flight1Airline.Endpoint, offer.Journey.Flight1.DepartureAirport)
-	airport, err := GetAirportInfo(endpoint)
+	airport, err := GetAirportInfo(ctx, endpoint)
 	if err != nil {
-		log.Errorf("Can't find info for departure airport: %s", err.Error())
+		logging.FromContext(ctx).Error("Can't find info for departure airport", "err", err)
 		return nil, err
 	}
 
@@ -66,27 +69,35 @@ func MakeRentRequest(rent models.Rent, offer models.Offer) (*BookRentResponse, e
 		"PickupDate":    offer.Journey.Flight1.DepartureTime.Add(-2 * time.Hour).Format("01/02/2006 15:04"),
 	}
 
+	span := tracing.StartSpan(ctx, "soap.client", fmt.Sprintf("BookRent %s", rent.Endpoint))
+	start := time.Now()
 	res, err := soap.Call("BookRent", params)
+	tracing.SetError(span, err)
+	span.Finish()
+	metrics.ObserveRequest("rent", start, soapStatus(err))
 	if err != nil {
-		log.Fatalf("Call error: %s", err)
+		logging.FromContext(ctx).Error("SOAP call failed", "action", "BookRent", "err", err)
 		return nil, err
 	}
 
 	var r BookRentResponse
-	res.Unmarshal(&r)
+	if err := res.Unmarshal(&r); err != nil {
+		logging.FromContext(ctx).Error("Invalid SOAP response", "action", "BookRent", "err", err)
+		return nil, fmt.Errorf("Invalid BookRent response: %w", err)
+	}
 
 	return &r, nil
 }
 
 // SOAP call to GetRentById action for a selected rent. Returns the reservation
 // object data
-func MakeGetRentByIdRequest(endpoint string, id string) (*GetRentByIdResponse, error) {
+func MakeGetRentByIdRequest(ctx context.Context, endpoint string, id string) (*GetRentByIdResponse, error) {
 	httpClient := &http.Client{
 		Timeout: 1500 * time.Millisecond,
 	}
 	soap, err := gosoap.SoapClient(endpoint, httpClient)
 	if err != nil {
-		log.Errorf("SoapClient error: %s", err)
+		logging.FromContext(ctx).Error("Failed to create the SOAP client", "err", err)
 		return nil, err
 	}
 
@@ -94,14 +105,30 @@ func MakeGetRentByIdRequest(endpoint string, id string) (*GetRentByIdResponse, e
 		"RentId": id,
 	}
 
+	span := tracing.StartSpan(ctx, "soap.client", fmt.Sprintf("GetRentById %s", endpoint))
+	start := time.Now()
 	res, err := soap.Call("GetRentById", params)
+	tracing.SetError(span, err)
+	span.Finish()
+	metrics.ObserveRequest("rent", start, soapStatus(err))
 	if err != nil {
-		log.Fatalf("Call error: %s", err)
+		logging.FromContext(ctx).Error("SOAP call failed", "action", "GetRentById", "err", err)
 		return nil, err
 	}
 
 	var r GetRentByIdResponse
-	res.Unmarshal(&r)
+	if err := res.Unmarshal(&r); err != nil {
+		logging.FromContext(ctx).Error("Invalid SOAP response", "action", "GetRentById", "err", err)
+		return nil, fmt.Errorf("Invalid GetRentById response: %w", err)
+	}
 
 	return &r, nil
 }
+
+// Returns the status label of a SOAP call
+func soapStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
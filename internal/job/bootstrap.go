@@ -0,0 +1,240 @@
+package job
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/acme-sky/workers/internal/db"
+	"github.com/acme-sky/workers/internal/models"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
+	"github.com/charmbracelet/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Deploy the resources at `paths` in a single deployment, unless the same
+// content has already been deployed. The checksum of every deployment is
+// stored in the database, so restarts and other replicas skip it. A Zeebe
+// which lost the deployment is found out by `CreateInstance`, which deploys
+// the resources again.
+func Deploy(client *zbc.Client, paths []string) error {
+	return deploy(client, paths, false)
+}
+
+// Deploy the resources at `paths`, even if the same content has already been
+// deployed when `force` is true
+func deploy(client *zbc.Client, paths []string, force bool) error {
+	contents := make([][]byte, len(paths))
+	hash := sha256.New()
+	for i, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		contents[i] = content
+		hash.Write([]byte(path))
+		hash.Write(content)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	resource := strings.Join(paths, ",")
+
+	conn, err := db.GetDb()
+	if err != nil {
+		return err
+	}
+
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := lock(tx, "deploy"); err != nil {
+			return err
+		}
+
+		var deployment models.Deployment
+		// Only the latest deployment is running, so reverting to a previous
+		// content deploys it again
+		err := tx.Where("resource = ?", resource).Order("id DESC").First(&deployment).Error
+		if !force && err == nil && deployment.Checksum == checksum {
+			log.Info("Resources already deployed", "resource", resource, "key", deployment.Key)
+			return nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		command := (*client).NewDeployResourceCommand()
+		for i, path := range paths {
+			command = command.AddResource(contents[i], path)
+		}
+
+		response, err := command.Send(context.Background())
+		if err != nil {
+			return err
+		}
+
+		logDeployment(response)
+
+		deployment = models.Deployment{Resource: resource, Checksum: checksum, Key: response.GetKey()}
+		return tx.Create(&deployment).Error
+	})
+}
+
+// Log every process, decision and form of a deployment
+func logDeployment(response *pb.DeployResourceResponse) {
+	log.Info("Deployment", "key", response.GetKey())
+
+	for _, deployment := range response.GetDeployments() {
+		if process := deployment.GetProcess(); process != nil {
+			log.Info("Deployed process", "process_id", process.GetBpmnProcessId(), "resource", process.GetResourceName(), "key", process.GetProcessDefinitionKey(), "version", process.GetVersion())
+		}
+		if decision := deployment.GetDecision(); decision != nil {
+			log.Info("Deployed decision", "decision_id", decision.GetDmnDecisionId(), "key", decision.GetDecisionKey(), "version", decision.GetVersion())
+		}
+		if requirements := deployment.GetDecisionRequirements(); requirements != nil {
+			log.Info("Deployed decision requirements", "decision_requirements_id", requirements.GetDmnDecisionRequirementsId(), "resource", requirements.GetResourceName(), "key", requirements.GetDecisionRequirementsKey(), "version", requirements.GetVersion())
+		}
+		if form := deployment.GetForm(); form != nil {
+			log.Info("Deployed form", "form_id", form.GetFormId(), "resource", form.GetResourceName(), "key", form.GetFormKey(), "version", form.GetVersion())
+		}
+	}
+}
+
+// Create an instance of `processId`, unless the last instance created by the
+// workers is still active. With `force` a new instance is always created.
+// The stored state is checked against Zeebe, since nothing updates it when the
+// instance reaches an end event. If Zeebe doesn't know `processId`, e.g. after
+// a reset, the resources are deployed again. It returns the key of the active
+// instance and whether it has been created now.
+func CreateInstance(client *zbc.Client, processId string, force bool) (int64, bool, error) {
+	conn, err := db.GetDb()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var key int64
+	var created bool
+
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		if err := lock(tx, "instance:"+processId); err != nil {
+			return err
+		}
+
+		if !force {
+			var last models.ProcessInstance
+			err := tx.Where("process_id = ?", processId).Order("id DESC").First(&last).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			if err == nil && last.State == models.InstanceActive {
+				active, err := isInstanceActive(context.Background(), client, last.Key)
+				if err != nil {
+					return err
+				}
+				if active {
+					key = last.Key
+					return nil
+				}
+
+				now := time.Now()
+				if err := tx.Model(&last).Updates(map[string]interface{}{"state": models.InstanceEnded, "ended_at": &now}).Error; err != nil {
+					return err
+				}
+				log.Info("Instance ended", "process_id", processId, "key", last.Key)
+			}
+		}
+
+		var airlines []models.Airline
+		if err := tx.Find(&airlines).Error; err != nil {
+			return err
+		}
+		// Airlines must be loaded for the first time as variables 'cause the timer
+		// trigger executed every hour.
+		variables := map[string]interface{}{"airlines": airlines}
+
+		instance, err := (*client).NewCreateInstanceCommand().BPMNProcessId(processId).LatestVersion().VariablesFromMap(variables)
+		if err != nil {
+			return err
+		}
+
+		result, err := instance.Send(context.Background())
+		if status.Code(err) == codes.NotFound {
+			log.Warn("Process not deployed, deploying the resources again", "process_id", processId)
+			if err := redeploy(client); err != nil {
+				return err
+			}
+
+			result, err = instance.Send(context.Background())
+		}
+		if err != nil {
+			return err
+		}
+		log.Info("Created instance", "process_id", result.GetBpmnProcessId(), "key", result.GetProcessInstanceKey(), "version", result.GetVersion())
+
+		key = result.GetProcessInstanceKey()
+		created = true
+
+		return tx.Create(&models.ProcessInstance{ProcessId: processId, Key: key, State: models.InstanceActive}).Error
+	})
+
+	return key, created, err
+}
+
+// Deploy the resources of the workers again, e.g. to a Zeebe which has been
+// reset while the database has been kept
+func redeploy(client *zbc.Client) error {
+	resources, err := DeployedResources()
+	if err != nil {
+		return err
+	}
+
+	return deploy(client, resources, true)
+}
+
+// Returns true if the process instance `key` is still active. The gateway
+// can't be queried for instances, so empty variables are set on its scope:
+// the command is rejected as not found once the instance is completed or
+// canceled, or if Zeebe has been reset.
+func isInstanceActive(ctx context.Context, client *zbc.Client, key int64) (bool, error) {
+	command, err := (*client).NewSetVariablesCommand().ElementInstanceKey(key).VariablesFromString("{}")
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := command.Send(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Set the `state` of the process instance `key`, if it has been created by the
+// workers and it's still active. Instances not created by the workers are
+// ignored.
+func EndInstance(key int64, state string) error {
+	conn, err := db.GetDb()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return conn.Model(&models.ProcessInstance{}).
+		Where("key = ? AND state = ?", key, models.InstanceActive).
+		Updates(map[string]interface{}{"state": state, "ended_at": &now}).Error
+}
+
+// Take a PostgreSQL advisory lock on `name` for the transaction `tx`, so
+// replicas starting together don't deploy or create instances twice.
+func lock(tx *gorm.DB, name string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", name).Error
+}
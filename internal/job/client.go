@@ -0,0 +1,140 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
+	"github.com/knadh/koanf/v2"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+)
+
+// Audience and authorization server used by Camunda SaaS clusters
+const (
+	saasAudience         = "zeebe.camunda.io"
+	saasAuthorizationURL = "https://login.cloud.camunda.io/oauth/token"
+)
+
+// Build the Zeebe client config from `conf`:
+//
+//   - ZEEBE_ADDRESS: address of the gateway, e.g. `zeebe:26500`
+//   - CAMUNDA_CLUSTER_ID and CAMUNDA_CLUSTER_REGION: connect to a Camunda SaaS
+//     cluster instead of `ZEEBE_ADDRESS`. It always uses TLS and OAuth
+//   - ZEEBE_AUTHENTICATION_MODE: `none` (default) or `identity` to request an
+//     OAuth token with `ZEEBE_CLIENT_ID` and `ZEEBE_CLIENT_SECRET` from
+//     `ZEEBE_AUTHORIZATION_SERVER_URL`. `ZEEBE_TOKEN_AUDIENCE` defaults to the
+//     gateway host and `ZEEBE_TOKEN_SCOPE` is optional
+//   - ZEEBE_TLS: `true` to use TLS, implied by ZEEBE_CA_CERTIFICATE_PATH
+//   - ZEEBE_CA_CERTIFICATE_PATH: custom CA used to verify the gateway
+//
+// The Zeebe client still applies its own environment variables on top of
+// this config, e.g. `ZEEBE_ADDRESS` wins over the SaaS cluster address.
+func clientConfig(conf *koanf.Koanf) (*zbc.ClientConfig, error) {
+	config := &zbc.ClientConfig{
+		GatewayAddress:         conf.String("zeebe.address"),
+		UsePlaintextConnection: true,
+		DialOpts:               []grpc.DialOption{grpc.WithChainStreamInterceptor(pollInterceptor())},
+	}
+
+	mode := conf.String("zeebe.authentication.mode")
+	audience := conf.String("zeebe.token.audience")
+	authorizationURL := conf.String("zeebe.authorization.server.url")
+
+	if clusterId := conf.String("camunda.cluster.id"); len(clusterId) > 0 {
+		region := conf.String("camunda.cluster.region")
+		if len(region) == 0 {
+			return nil, errors.New("`CAMUNDA_CLUSTER_REGION` is required with `CAMUNDA_CLUSTER_ID`")
+		}
+
+		config.GatewayAddress = fmt.Sprintf("%s.%s.%s:443", clusterId, region, saasAudience)
+		config.UsePlaintextConnection = false
+		mode = "identity"
+
+		if len(audience) == 0 {
+			audience = saasAudience
+		}
+		if len(authorizationURL) == 0 {
+			authorizationURL = saasAuthorizationURL
+		}
+	}
+
+	if caPath := conf.String("zeebe.ca.certificate.path"); len(caPath) > 0 {
+		config.CaCertificatePath = caPath
+		config.UsePlaintextConnection = false
+	}
+	if conf.Bool("zeebe.tls") {
+		config.UsePlaintextConnection = false
+	}
+
+	switch mode {
+	case "", "none":
+		// The Zeebe client enables OAuth by itself if `ZEEBE_CLIENT_ID` is
+		// set, so an explicit provider is needed to keep it disabled.
+		config.CredentialsProvider = &noCredentialsProvider{}
+	case "identity", "oauth":
+		if len(audience) == 0 {
+			audience = strings.Split(config.GatewayAddress, ":")[0]
+		}
+
+		provider, err := zbc.NewOAuthCredentialsProvider(&zbc.OAuthProviderConfig{
+			ClientID:               conf.String("zeebe.client.id"),
+			ClientSecret:           conf.String("zeebe.client.secret"),
+			Audience:               audience,
+			Scope:                  conf.String("zeebe.token.scope"),
+			AuthorizationServerURL: authorizationURL,
+			Cache:                  &memoryCredentialsCache{tokens: map[string]*oauth2.Token{}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		config.CredentialsProvider = provider
+	default:
+		return nil, fmt.Errorf("Invalid `ZEEBE_AUTHENTICATION_MODE` `%s`", mode)
+	}
+
+	return config, nil
+}
+
+// Credentials provider which doesn't add any header
+type noCredentialsProvider struct{}
+
+func (noCredentialsProvider) ApplyCredentials(_ context.Context, _ map[string]string) error {
+	return nil
+}
+
+func (noCredentialsProvider) ShouldRetryRequest(_ context.Context, _ error) bool {
+	return false
+}
+
+// OAuth tokens cache kept in memory. The default one of the Zeebe client
+// writes in the home directory, which is not writable in every container.
+// The provider requests a new token when the cached one expires or the
+// gateway rejects it.
+type memoryCredentialsCache struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+func (c *memoryCredentialsCache) Refresh() error {
+	return nil
+}
+
+func (c *memoryCredentialsCache) Get(audience string) *oauth2.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokens[audience]
+}
+
+func (c *memoryCredentialsCache) Update(audience string, token *oauth2.Token) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens[audience] = token
+	return nil
+}
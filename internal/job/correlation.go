@@ -0,0 +1,58 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resolve the correlation key for a message. If `variable` is not empty, the
+// key is read from `variables` and `key` is ignored. A dotted path like
+// `offer.token` reads a field of a nested object.
+// The resolved key can't be empty.
+func ResolveCorrelationKey(key string, variable string, variables map[string]interface{}) (string, error) {
+	if len(variable) == 0 {
+		if len(key) == 0 {
+			return "", errors.New("Correlation key is empty")
+		}
+
+		return key, nil
+	}
+
+	var value interface{} = variables
+	for _, field := range strings.Split(variable, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("Correlation variable `%s` is not an object at `%s`", variable, field)
+		}
+
+		if value, ok = object[field]; !ok || value == nil {
+			return "", fmt.Errorf("Correlation variable `%s` is not set", variable)
+		}
+	}
+
+	var result string
+	switch v := value.(type) {
+	case string:
+		result = v
+	case float64:
+		// Numbers from JSON variables are always float64, but ids must be
+		// formatted without decimals.
+		if v == float64(int64(v)) {
+			result = strconv.FormatInt(int64(v), 10)
+		} else {
+			result = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	case int, int64, uint, uint64, bool:
+		result = fmt.Sprint(v)
+	default:
+		return "", fmt.Errorf("Correlation variable `%s` has an invalid type %T", variable, value)
+	}
+
+	if len(result) == 0 {
+		return "", fmt.Errorf("Correlation variable `%s` is empty", variable)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package job
+
+import "testing"
+
+func TestResolveCorrelationKey(t *testing.T) {
+	variables := map[string]interface{}{
+		"token":   "abc",
+		"user_id": float64(42),
+		"price":   12.5,
+		"empty":   "",
+		"missing": nil,
+		"offer": map[string]interface{}{
+			"token": "def",
+			"id":    float64(7),
+		},
+		"list": []interface{}{"a"},
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		variable string
+		want     string
+		wantErr  bool
+	}{
+		{name: "static key", key: "0", want: "0"},
+		{name: "empty static key", wantErr: true},
+		{name: "string variable", variable: "token", want: "abc"},
+		{name: "variable has precedence", key: "0", variable: "token", want: "abc"},
+		{name: "float id without decimals", variable: "user_id", want: "42"},
+		{name: "float with decimals", variable: "price", want: "12.5"},
+		{name: "dotted path", variable: "offer.token", want: "def"},
+		{name: "dotted path to float id", variable: "offer.id", want: "7"},
+		{name: "missing variable", variable: "unknown", wantErr: true},
+		{name: "null variable", variable: "missing", wantErr: true},
+		{name: "empty variable", variable: "empty", wantErr: true},
+		{name: "missing nested field", variable: "offer.unknown", wantErr: true},
+		{name: "path through a non object", variable: "token.value", wantErr: true},
+		{name: "invalid type", variable: "list", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveCorrelationKey(tt.key, tt.variable, variables)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
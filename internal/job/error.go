@@ -0,0 +1,47 @@
+package job
+
+import (
+	"context"
+
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
+)
+
+// Domain error caught by a BPMN error event. When a handler fails with this
+// error, the job is not failed: the error is thrown to the process so it can
+// be routed through the modeled boundary events.
+type BPMNError struct {
+	// Error code of the BPMN error, e.g. `EB_Book_Journey`
+	Code string
+
+	// Human-readable message shown in Operate
+	Message string
+
+	// Variables set on the scope of the catching event
+	Variables map[string]interface{}
+}
+
+func (e *BPMNError) Error() string {
+	return e.Message
+}
+
+// Returns a new BPMN error with `code` and `message`. `variables` can be nil.
+func NewBPMNError(code string, message string, variables map[string]interface{}) error {
+	return &BPMNError{Code: code, Message: message, Variables: variables}
+}
+
+// Send a `ThrowErrorCommand` for the `job` with the data of `bpmnErr`
+func throwError(ctx context.Context, client worker.JobClient, job entities.Job, bpmnErr *BPMNError) {
+	command := client.NewThrowErrorCommand().JobKey(job.GetKey()).ErrorCode(bpmnErr.Code).ErrorMessage(bpmnErr.Message)
+	if bpmnErr.Variables != nil {
+		var err error
+		if command, err = command.VariablesFromMap(bpmnErr.Variables); err != nil {
+			logging.FromContext(ctx).Error("Error setting the BPMN error variables", "err", err)
+		}
+	}
+
+	if _, err := command.Send(ctx); err != nil {
+		logging.FromContext(ctx).Error("Error throwing the BPMN error", "code", bpmnErr.Code, "err", err)
+	}
+}
@@ -2,64 +2,44 @@ package job
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"time"
 
 	"github.com/acme-sky/workers/internal/config"
-	"github.com/acme-sky/workers/internal/db"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/acme-sky/workers/internal/models"
 	"github.com/charmbracelet/log"
 
-	"github.com/camunda/zeebe/clients/go/v8/pkg/commands"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
-	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
 )
 
-// A safer map using a mutex system to avoid concurrent writes
-type jobStatusesMap struct {
-	mu sync.Mutex
-	m  map[string](chan int64)
-}
-
-// Map used to sync jobs
-var JobStatuses = jobStatusesMap{m: make(map[string](chan int64))}
-
-// Map used to sync variables for jobs
-var JobVariables = make(map[string](chan map[string]interface{}))
-
-// Set function for a `key` in the map
-func (sm *jobStatusesMap) Set(key string, value chan int64) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.m[key] = value
-}
-
-// This function should closes the channel but, since we have an issue here,
-// just edit the value.
-// FIXME: should close the channel `close(sm.m[key])`
-func (sm *jobStatusesMap) Close(key string, value int64) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.m[key] <- value
-}
-
-// Get the value for the map with a `key`
-func (sm *jobStatusesMap) Get(key string) (chan int64, bool) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	value, ok := sm.m[key]
-	return value, ok
-}
-
 // Struct used for the publish message command executed by the client.
 type MessageCommand struct {
 	// Name of the BPMN' message catch event
 	Name string
 
-	// Correlation key of the message catch event. Actually, we do not really
-	// use this field properly
+	// Static correlation key of the message catch event. It is used only if
+	// `CorrelationVariable` is empty.
 	CorrelationKey string
+
+	// Name of the job variable used as correlation key, e.g. `token` or
+	// `offer.token` for a field of the `offer` object.
+	CorrelationVariable string
+}
+
+// Function which processes an activated job. It returns the variables used to
+// complete the job or an error used to fail it. `ctx` carries the Sentry
+// transaction of the job, so it must be passed to DB queries and outbound
+// calls.
+type HandlerFunc func(ctx context.Context, job entities.Job) (map[string]interface{}, error)
+
+// Handler which completes the job without changing its variables, for tasks
+// which only mark a point of the process
+func Noop(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+	return nil, nil
 }
 
 // The Job structure used by all the BPMN activities
@@ -68,138 +48,237 @@ type Job struct {
 	Name string
 
 	// Handler function
-	Handler worker.JobHandler
+	Handler HandlerFunc
 
 	// A possible message to send after the response of the hanlder
 	Message *MessageCommand
+
+	// Retry policy used on failures. If nil, `DefaultRetryPolicy` is used
+	Retry *RetryPolicy
+
+	// Retries of the BPMN task, set by `ValidateRegistry`. They are used to
+	// count the attempts for the backoff. If zero, the Zeebe default of 3 is
+	// used
+	TaskRetries int32
+
+	// Middlewares used only by this job. They run inside the ones passed to
+	// `Handle`
+	Middlewares []Middleware
+
+	// Max number of jobs activated by the worker at the same time. If zero,
+	// the Zeebe client default is used
+	MaxJobsActive int
+
+	// Number of goroutines which run the handler. If zero, the Zeebe client
+	// default is used
+	Concurrency int
+
+	// Time after which an activated job is given to another worker. If zero,
+	// the Zeebe client default is used
+	Timeout time.Duration
+
+	// Interval between two polls for new jobs. If zero, the Zeebe client
+	// default is used
+	PollInterval time.Duration
+
+	// Variables fetched on activation. If empty, all the variables visible
+	// from the task are fetched
+	FetchVariables []string
 }
 
-// Handle the job instance for the `client`
-func (job *Job) Handle(client *zbc.Client) {
-	ctx := context.Background()
+// Open a job worker for the `client`. The handler is wrapped by `middlewares`
+// and then by the job's ones. Every activated job is completed with the
+// variables returned by the handler and then the follow-up message is
+// published; if the handler returns an error, the job fails.
+func (job *Job) Handle(client *zbc.Client, middlewares ...Middleware) worker.JobWorker {
+	chain := append(append([]Middleware{}, middlewares...), job.Middlewares...)
+	handler := Chain(job.Handler, chain...)
 
-	// Start all the channel used to sync status, variables and after function
-	ch := make(chan int64, 1)
-	JobStatuses.Set(job.Name, ch)
+	settings := job.settings()
 
-	JobVariables[job.Name] = make(chan map[string]interface{}, 1)
+	// Slots of the handlers which run at the same time. Activated jobs wait
+	// here, so they are still handled after the worker is closed.
+	slots := make(chan struct{}, settings.concurrency())
 
-	// TODO: study why multi-instance jobs does not fit this close-worker below
-	// worker := (*client).NewJobWorker().JobType(job.Name).Handler(job.Handler).Open()
-	(*client).NewJobWorker().JobType(job.Name).Handler(job.Handler).Open()
+	builder := (*client).NewJobWorker().JobType(job.Name).Handler(func(jobClient worker.JobClient, activated entities.Job) {
+		running.Add(1)
+		defer running.Add(-1)
 
-	if job.Message != nil {
-		// It waites until `JobVariables[job.Name]` returns a value. Then it
-		// publishes the message
-		var variables map[string]interface{}
-		ok := true
-		select {
-		case variables, ok = <-JobVariables[job.Name]:
-			if !ok {
-				log.Errorf("Channel JobVariables for %s is already closed\n", job.Name)
-				panic("Reuse of closed channel")
-			}
-		}
-		res, err := (*client).NewPublishMessageCommand().MessageName(job.Message.Name).CorrelationKey(job.Message.CorrelationKey).VariablesFromMap(variables)
-
-		if err != nil {
-			log.Error(err.Error())
-		} else {
-			if _, err := res.Send(ctx); err != nil {
-				log.Error(err.Error())
-			} else {
-				log.Infof("Sent message to `%s` with correlation key = `%s`\n", job.Message.Name, job.Message.CorrelationKey)
+		slots <- struct{}{}
+		defer func() { <-slots }()
+
+		job.run(client, jobClient, activated, handler)
+	})
+
+	jobWorker := settings.apply(builder).Open()
+	setWorkerOpen(job.Name, true)
+
+	return jobWorker
+}
+
+// Run the `handler` for the `activated` job, inside a Sentry transaction, and
+// send the resulting command. In case of a failure without retries left, the
+// process instance is canceled, unless the handler panicked: that incident is
+// kept to be resolved. The logger of `ctx` carries the job fields.
+func (job *Job) run(client *zbc.Client, jobClient worker.JobClient, activated entities.Job, handler HandlerFunc) {
+	ctx, transaction := startTransaction(logging.WithJob(context.Background(), activated), activated)
+
+	variables, final, err := job.execute(ctx, jobClient, activated, handler)
+	defer finishTransaction(transaction, err)
+
+	if err != nil {
+		if final && !IsPanic(err) {
+			pid := activated.GetProcessInstanceKey()
+			if _, err := (*client).NewCancelInstanceCommand().ProcessInstanceKey(pid).Send(ctx); err != nil {
+				logging.FromContext(ctx).Error("Error canceling the instance", "err", err)
+			} else if err := EndInstance(pid, models.InstanceCanceled); err != nil {
+				logging.FromContext(ctx).Error("Error saving the instance state", "err", err)
 			}
 		}
+		return
 	}
-	// worker.Close()
-	// worker.AwaitClose()
 
-	value, _ := JobStatuses.Get(job.Name)
-	pid := <-value
-	ch <- pid
-	if pid != 0 {
-		if _, err := (*client).NewCancelInstanceCommand().ProcessInstanceKey(pid).Send(ctx); err != nil {
-			log.Errorf("Error canceling the instance: %s", err.Error())
-		}
+	if job.Message != nil {
+		job.publish(ctx, client, variables)
 	}
+}
+
+// Run the job handler, wrapped by its own middlewares, for `activated` and
+// send the resulting command with `jobClient`. The follow-up message is not
+// published and the instance is never canceled, so it can be used with a fake
+// job client in tests.
+func (job *Job) Execute(ctx context.Context, jobClient worker.JobClient, activated entities.Job) (map[string]interface{}, error) {
+	ctx = logging.WithJob(ctx, activated)
+	variables, _, err := job.execute(ctx, jobClient, activated, Chain(job.Handler, job.Middlewares...))
 
-	job.Handle(client)
+	return variables, err
 }
 
-// Job used in case of a failure. Create a new `FailJobCommand` and retry. In
-// case of error on the "retry", just panic the routine.
-func FailJob(client worker.JobClient, job entities.Job) {
-	log.Error("Failed to complete job", "job", job.GetKey())
+// Run `handler` for `activated` and complete the job with its variables, or
+// fail it or throw a BPMN error if it returns an error. It returns true if the
+// failure is final.
+func (job *Job) execute(ctx context.Context, jobClient worker.JobClient, activated entities.Job, handler HandlerFunc) (map[string]interface{}, bool, error) {
+	variables, err := handler(ctx, activated)
+	if err == nil {
+		err = completeJob(ctx, jobClient, activated, variables)
+	}
 
-	ctx := context.Background()
-	_, err := client.NewFailJobCommand().JobKey(job.GetKey()).Retries(0).Send(ctx)
 	if err != nil {
-		log.Errorf("Error %s", err.Error())
+		return variables, failJob(ctx, jobClient, activated, job.retryPolicy(), job.TaskRetries, err), err
 	}
 
-	JobStatuses.Close(job.Type, job.ProcessInstanceKey)
+	return variables, false, nil
 }
 
-// Main function whcih creates a new Zeebe client.
-// If called with the parameter `pid`, that value will be run as `ProcessId`
-func CreateClient(pid string) *zbc.Client {
-	var err error
+// Returns the retry policy of the job
+func (job *Job) retryPolicy() RetryPolicy {
+	if job.Retry != nil {
+		return *job.Retry
+	}
+	return DefaultRetryPolicy
+}
 
-	// Load some variables from the environment
-	conf, err := config.GetConfig()
+// Publish the message of the job with `variables` as payload
+func (job *Job) publish(ctx context.Context, client *zbc.Client, variables map[string]interface{}) {
+	logger := logging.FromContext(ctx).With("message", job.Message.Name)
 
+	correlationKey, err := ResolveCorrelationKey(job.Message.CorrelationKey, job.Message.CorrelationVariable, variables)
 	if err != nil {
-		log.Fatalf("Error loading the config: %s", err.Error())
+		logger.Error("Can't send message", "err", err)
+		return
 	}
 
-	ZeebeAddr := conf.String("zeebe.address")
-	BPMNFile := conf.String("bpmn.file")
-	ProcessId := conf.String("process.id")
+	res, err := (*client).NewPublishMessageCommand().MessageName(job.Message.Name).CorrelationKey(correlationKey).VariablesFromMap(variables)
 
-	if len(pid) != 0 {
-		ProcessId = pid
+	if err != nil {
+		logger.Error("Can't send message", "err", err)
+		return
 	}
 
-	var client zbc.Client
+	if _, err := res.Send(ctx); err != nil {
+		logger.Error("Can't send message", "err", err)
+	} else {
+		logger.Info("Sent message", "correlation_key", correlationKey)
+		metrics.MessagesPublished.WithLabelValues(job.Message.Name, metrics.SourceJob).Inc()
+	}
+}
 
-	if client, err = zbc.NewClient(&zbc.ClientConfig{
-		GatewayAddress:         ZeebeAddr,
-		UsePlaintextConnection: true,
-	}); err != nil {
-		panic(err)
+// Complete the `job` with `variables`
+func completeJob(ctx context.Context, client worker.JobClient, job entities.Job, variables map[string]interface{}) error {
+	request, err := client.NewCompleteJobCommand().JobKey(job.GetKey()).VariablesFromMap(variables)
+	if err != nil {
+		return err
 	}
 
-	ctx := context.Background()
+	_, err = request.Send(ctx)
+	return err
+}
 
-	var response *pb.DeployResourceResponse
+// Function used in case of a failure. Create a new `FailJobCommand` with the
+// error message of `err`. Fatal errors and jobs without retries left raise an
+// incident: in this case it returns true and the process instance should be
+// canceled. Any other error is retried after the backoff defined by `policy`,
+// counting the attempts from the `taskRetries` of the BPMN task.
+// Every failure is captured on Sentry, but a `BPMNError` is thrown to the
+// process instead of failing the job.
+func failJob(ctx context.Context, client worker.JobClient, job entities.Job, policy RetryPolicy, taskRetries int32, err error) bool {
+	var bpmnErr *BPMNError
+	if errors.As(err, &bpmnErr) {
+		throwError(ctx, client, job, bpmnErr)
+		return false
+	}
 
-	if response, err = client.NewDeployResourceCommand().AddResourceFile(BPMNFile).Send(ctx); err != nil {
-		panic(err)
+	captureError(ctx, err)
+
+	retries := int32(0)
+	if !policy.isFatal(err) {
+		retries = policy.retries(job.GetRetries())
 	}
 
-	log.Infof(response.String())
+	command := client.NewFailJobCommand().JobKey(job.GetKey()).Retries(retries).ErrorMessage(err.Error())
+	if retries > 0 {
+		command = command.RetryBackoff(policy.backoff(taskRetries, retries))
+	}
 
-	db, _ := db.GetDb()
-	var airlines []models.Airline
-	if err := db.Find(&airlines).Error; err != nil {
-		panic("can't find airlines")
+	if _, err := command.Send(ctx); err != nil {
+		logging.FromContext(ctx).Error("Error failing the job", "err", err)
 	}
-	// Airlines must be loaded for the first time as variables 'cause the timer
-	// trigger executed every hour.
-	variables := map[string]interface{}{"airlines": airlines}
 
-	var instance commands.CreateInstanceCommandStep3
-	if instance, err = client.NewCreateInstanceCommand().BPMNProcessId(ProcessId).LatestVersion().VariablesFromMap(variables); err != nil {
+	return retries == 0
+}
+
+// Main function whcih creates a new Zeebe client and deploys the resources
+// matched by `BPMN_RESOURCES`, or just `BPMN_FILE` if it's not set. Nothing is
+// deployed if they are not changed since the last deployment.
+func CreateClient() *zbc.Client {
+	var err error
+
+	// Load some variables from the environment
+	conf, err := config.GetConfig()
+
+	if err != nil {
+		log.Fatalf("Error loading the config: %s", err.Error())
+	}
+
+	clientConf, err := clientConfig(conf)
+	if err != nil {
+		log.Fatalf("Error on Zeebe client config: %s", err.Error())
+	}
+
+	var client zbc.Client
+
+	if client, err = zbc.NewClient(clientConf); err != nil {
 		panic(err)
 	}
 
-	var result *pb.CreateProcessInstanceResponse
+	resources, err := DeployedResources()
+	if err != nil {
+		panic(err)
+	}
 
-	if result, err = instance.Send(ctx); err != nil {
+	if err = Deploy(&client, resources); err != nil {
 		panic(err)
 	}
-	log.Infof(result.String())
 
 	return &client
 }
@@ -0,0 +1,85 @@
+package jobtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Assert that `job` has been completed and return the variables sent with the
+// complete command
+func AssertCompleted(t testing.TB, client *JobClient, job entities.Job) map[string]interface{} {
+	t.Helper()
+
+	command := assertSingle(t, client, job, Complete)
+	return command.Variables
+}
+
+// Assert that `job` has failed with `retries` left and return the command
+func AssertFailed(t testing.TB, client *JobClient, job entities.Job, retries int32) Command {
+	t.Helper()
+
+	command := assertSingle(t, client, job, Fail)
+	if command.Retries != retries {
+		t.Fatalf("job %d failed with %d retries, want %d", job.GetKey(), command.Retries, retries)
+	}
+
+	return command
+}
+
+// Assert that `job` has thrown the BPMN error `code` and return the command
+func AssertThrown(t testing.TB, client *JobClient, job entities.Job, code string) Command {
+	t.Helper()
+
+	command := assertSingle(t, client, job, ThrowError)
+	if command.ErrorCode != code {
+		t.Fatalf("job %d threw error `%s`, want `%s`", job.GetKey(), command.ErrorCode, code)
+	}
+
+	return command
+}
+
+// Assert that the variable `name` is set to `want` in `variables`. Numbers
+// are compared as float64, like they are decoded from JSON.
+func AssertVariable(t testing.TB, variables map[string]interface{}, name string, want interface{}) {
+	t.Helper()
+
+	got, ok := variables[name]
+	if !ok {
+		t.Fatalf("variable `%s` is not set", name)
+	}
+
+	if !reflect.DeepEqual(normalize(got), normalize(want)) {
+		t.Fatalf("variable `%s` = %#v, want %#v", name, got, want)
+	}
+}
+
+// Assert that exactly one command has been sent for `job` and that it's of
+// `kind`
+func assertSingle(t testing.TB, client *JobClient, job entities.Job, kind CommandKind) Command {
+	t.Helper()
+
+	commands := client.CommandsFor(job.GetKey())
+	if len(commands) != 1 {
+		t.Fatalf("job %d sent %d commands, want 1: %+v", job.GetKey(), len(commands), commands)
+	}
+
+	if commands[0].Kind != kind {
+		t.Fatalf("job %d sent a `%s` command, want `%s`: %s", job.GetKey(), commands[0].Kind, kind, commands[0].ErrorMessage)
+	}
+
+	return commands[0]
+}
+
+// Round-trip `value` through JSON, so Go values can be compared with decoded
+// variables
+func normalize(value interface{}) interface{} {
+	var result interface{}
+	if err := json.Unmarshal([]byte(mustMarshal(value)), &result); err != nil {
+		return value
+	}
+
+	return result
+}
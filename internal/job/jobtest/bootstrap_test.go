@@ -0,0 +1,40 @@
+package jobtest
+
+import (
+	"context"
+	"testing"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+)
+
+func TestCreateInstanceChecksZeebe(t *testing.T) {
+	UseDatabase(t)
+	UseConfig(t, map[string]string{"BPMN_RESOURCES": "../../../bpmn/"})
+
+	gateway := NewGateway(t)
+	client := gateway.Client(t)
+
+	// Nothing is deployed, like on a Zeebe which has been reset
+	first, created, err := acmejob.CreateInstance(client, "Process_User", false)
+	if err != nil || !created {
+		t.Fatalf("CreateInstance = %d, %t, %v, want a new instance", first, created, err)
+	}
+	if len(gateway.Resources()) == 0 {
+		t.Error("the resources must be deployed again")
+	}
+
+	again, created, err := acmejob.CreateInstance(client, "Process_User", false)
+	if err != nil || created || again != first {
+		t.Fatalf("CreateInstance = %d, %t, %v, want the active instance %d", again, created, err, first)
+	}
+
+	// Ended outside the workers, like at an end event
+	if _, err := (*client).NewCancelInstanceCommand().ProcessInstanceKey(first).Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, created, err := acmejob.CreateInstance(client, "Process_User", false)
+	if err != nil || !created || replaced == first {
+		t.Fatalf("CreateInstance = %d, %t, %v, want a new instance", replaced, created, err)
+	}
+}
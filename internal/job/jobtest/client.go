@@ -0,0 +1,190 @@
+// Package jobtest provides a fake Zeebe job client and helpers to unit test
+// job handlers without a broker.
+package jobtest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/commands"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
+	"google.golang.org/grpc"
+)
+
+// Kind of a command sent by a job handler
+type CommandKind string
+
+const (
+	Complete   CommandKind = "complete"
+	Fail       CommandKind = "fail"
+	ThrowError CommandKind = "throw_error"
+)
+
+// Command recorded by the fake job client
+type Command struct {
+	Kind   CommandKind
+	JobKey int64
+
+	// Variables sent with the command, nil if none
+	Variables map[string]interface{}
+
+	// Only for `Fail`
+	Retries      int32
+	RetryBackoff time.Duration
+
+	// Only for `Fail` and `ThrowError`
+	ErrorMessage string
+
+	// Only for `ThrowError`
+	ErrorCode string
+}
+
+// Fake `worker.JobClient` which records every command instead of sending it
+// to a gateway. It uses the commands of the Zeebe client, so the requests are
+// built exactly as in production. It's safe for concurrent use.
+type JobClient struct {
+	mu       sync.Mutex
+	commands []Command
+
+	// If set, every command fails with this error
+	Err error
+}
+
+var _ worker.JobClient = (*JobClient)(nil)
+
+// Returns a new fake job client without commands
+func NewJobClient() *JobClient {
+	return &JobClient{}
+}
+
+func (c *JobClient) NewCompleteJobCommand() commands.CompleteJobCommandStep1 {
+	return commands.NewCompleteJobCommand(&gateway{client: c}, noRetry)
+}
+
+func (c *JobClient) NewFailJobCommand() commands.FailJobCommandStep1 {
+	return commands.NewFailJobCommand(&gateway{client: c}, noRetry)
+}
+
+func (c *JobClient) NewThrowErrorCommand() commands.ThrowErrorCommandStep1 {
+	return commands.NewThrowErrorCommand(&gateway{client: c}, noRetry)
+}
+
+// Returns a copy of the recorded commands, in the order they were sent
+func (c *JobClient) Commands() []Command {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Command{}, c.commands...)
+}
+
+// Returns the recorded commands for the job `key`
+func (c *JobClient) CommandsFor(key int64) []Command {
+	var result []Command
+	for _, command := range c.Commands() {
+		if command.JobKey == key {
+			result = append(result, command)
+		}
+	}
+
+	return result
+}
+
+// Remove every recorded command
+func (c *JobClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commands = nil
+}
+
+func (c *JobClient) record(command Command) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Err != nil {
+		return c.Err
+	}
+
+	c.commands = append(c.commands, command)
+	return nil
+}
+
+// The job client commands never retry a request
+func noRetry(context.Context, error) bool {
+	return false
+}
+
+// Gateway which records the job commands on `client`. Any other call panics,
+// because the job client never sends them.
+type gateway struct {
+	pb.GatewayClient
+	client *JobClient
+}
+
+func (g *gateway) CompleteJob(_ context.Context, in *pb.CompleteJobRequest, _ ...grpc.CallOption) (*pb.CompleteJobResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.client.record(Command{Kind: Complete, JobKey: in.GetJobKey(), Variables: variables}); err != nil {
+		return nil, err
+	}
+	return &pb.CompleteJobResponse{}, nil
+}
+
+func (g *gateway) FailJob(_ context.Context, in *pb.FailJobRequest, _ ...grpc.CallOption) (*pb.FailJobResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, err
+	}
+
+	command := Command{
+		Kind:         Fail,
+		JobKey:       in.GetJobKey(),
+		Variables:    variables,
+		Retries:      in.GetRetries(),
+		RetryBackoff: time.Duration(in.GetRetryBackOff()) * time.Millisecond,
+		ErrorMessage: in.GetErrorMessage(),
+	}
+	if err := g.client.record(command); err != nil {
+		return nil, err
+	}
+	return &pb.FailJobResponse{}, nil
+}
+
+func (g *gateway) ThrowError(_ context.Context, in *pb.ThrowErrorRequest, _ ...grpc.CallOption) (*pb.ThrowErrorResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, err
+	}
+
+	command := Command{
+		Kind:         ThrowError,
+		JobKey:       in.GetJobKey(),
+		Variables:    variables,
+		ErrorCode:    in.GetErrorCode(),
+		ErrorMessage: in.GetErrorMessage(),
+	}
+	if err := g.client.record(command); err != nil {
+		return nil, err
+	}
+	return &pb.ThrowErrorResponse{}, nil
+}
+
+// Decode the JSON variables of a request, nil if they are empty
+func decodeVariables(variables string) (map[string]interface{}, error) {
+	if len(variables) == 0 {
+		return nil, nil
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(variables), &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
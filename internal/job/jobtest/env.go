@@ -0,0 +1,61 @@
+package jobtest
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/acme-sky/workers/internal/config"
+	"github.com/acme-sky/workers/internal/db"
+)
+
+var (
+	initDb    sync.Once
+	initDbErr error
+)
+
+// Set the environment variables `env` for the test and reload the config, so
+// handlers which read it see them
+func UseConfig(t testing.TB, env map[string]string) {
+	t.Helper()
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	if err := config.LoadConfig(); err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+}
+
+// Connect to the PostgreSQL database at `TEST_DATABASE_DSN` and run the test
+// inside a transaction, rolled back at the end, so handlers using the global
+// database don't leave any data. The test is skipped if the variable is not
+// set. Tests using it can't run in parallel.
+func UseDatabase(t testing.TB) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if len(dsn) == 0 {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	initDb.Do(func() {
+		_, initDbErr = db.InitDb(dsn)
+	})
+	if initDbErr != nil {
+		t.Fatalf("failed to connect database: %s", initDbErr.Error())
+	}
+
+	conn, _ := db.GetDb()
+	tx := conn.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin transaction: %s", tx.Error.Error())
+	}
+
+	previous := db.SetDb(tx)
+	t.Cleanup(func() {
+		tx.Rollback()
+		db.SetDb(previous)
+	})
+}
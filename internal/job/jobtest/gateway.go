@@ -0,0 +1,723 @@
+package jobtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// Time waited by `Wait` for the result of a job and by `WaitMessage`
+	// for a message
+	WaitTimeout = 10 * time.Second
+
+	// Max time an activation is kept open without jobs. It's shorter than the
+	// real one, so closing the workers at the end of a test is fast.
+	PollTimeout = 100 * time.Millisecond
+
+	// Max backoff before a failed job with retries left is activated again.
+	// The backoff requested by the worker is still recorded in its command.
+	MaxRetryBackoff = 100 * time.Millisecond
+)
+
+// Retries of the jobs queued with `Job`, like a BPMN task without the
+// `retries` attribute
+const defaultRetries int32 = 3
+
+// Process instance created on the fake gateway
+type Instance struct {
+	Key           int64
+	BpmnProcessId string
+	Variables     map[string]interface{}
+	Active        bool
+}
+
+// Message published on the fake gateway
+type Message struct {
+	Name           string
+	CorrelationKey string
+	MessageId      string
+	TimeToLive     time.Duration
+	Variables      map[string]interface{}
+}
+
+// In-process stand-in for the Zeebe gRPC gateway, listening on a local port.
+// It accepts deployments, creates instances and records published messages,
+// but it doesn't run the BPMN model: the test queues the jobs with `Job` in
+// the order of the process and waits for their result, so a scenario across
+// many handlers is deterministic. Completed variables are merged into the
+// instance, so the next job sees them. A failed job with retries left is
+// activated again after its backoff, capped to `MaxRetryBackoff`.
+type Gateway struct {
+	pb.UnimplementedGatewayServer
+
+	server   *grpc.Server
+	listener net.Listener
+
+	mu sync.Mutex
+	// Closed and replaced on every change, to wake up the long polling
+	// activations and the waiters
+	changed   chan struct{}
+	resources []string
+	processes map[string]int32
+	instances map[int64]*Instance
+	jobs      []*fakeJob
+	messages  []Message
+}
+
+// Job queued on the fake gateway
+type fakeJob struct {
+	key         int64
+	jobType     string
+	instanceKey int64
+	variables   map[string]interface{}
+	retries     int32
+	activated   bool
+
+	// Activated again only after this time, set by a failure with retries
+	availableAt time.Time
+
+	// Every command sent for the job, the last one is the result once
+	// `finished`
+	commands []Command
+	finished bool
+}
+
+// Start a fake gateway on a random local port. It's stopped at the end of the
+// test.
+func NewGateway(t testing.TB) *Gateway {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+
+	g := &Gateway{
+		server:    grpc.NewServer(),
+		listener:  listener,
+		changed:   make(chan struct{}),
+		processes: map[string]int32{},
+		instances: map[int64]*Instance{},
+	}
+	pb.RegisterGatewayServer(g.server, g)
+
+	go g.server.Serve(listener)
+	t.Cleanup(g.server.Stop)
+
+	return g
+}
+
+// Address of the gateway, to be used as `ZEEBE_ADDRESS`
+func (g *Gateway) Address() string {
+	return g.listener.Addr().String()
+}
+
+// Returns a plaintext Zeebe client for the gateway, closed at the end of the
+// test
+func (g *Gateway) Client(t testing.TB) *zbc.Client {
+	t.Helper()
+
+	client, err := zbc.NewClient(&zbc.ClientConfig{
+		GatewayAddress:         g.Address(),
+		UsePlaintextConnection: true,
+		CredentialsProvider:    noCredentials{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the Zeebe client: %s", err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return &client
+}
+
+// Create an instance of `processId` with `variables`, without a deployment,
+// and return its key
+func (g *Gateway) CreateInstance(processId string, variables map[string]interface{}) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.createInstance(processId, variables).Key
+}
+
+// Queue a job of `jobType` for the instance `instanceKey`, with the current
+// variables of the instance and 3 retries, and return its key. It's activated
+// by the next worker polling for its type.
+func (g *Gateway) Job(instanceKey int64, jobType string) int64 {
+	return g.JobWithRetries(instanceKey, jobType, defaultRetries)
+}
+
+// Queue a job like `Job` with `retries`, like the `retries` attribute of its
+// BPMN task
+func (g *Gateway) JobWithRetries(instanceKey int64, jobType string, retries int32) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	variables := map[string]interface{}{}
+	if instance, ok := g.instances[instanceKey]; ok {
+		for name, value := range instance.Variables {
+			variables[name] = value
+		}
+	}
+
+	job := &fakeJob{key: NextKey(), jobType: jobType, instanceKey: instanceKey, variables: variables, retries: retries}
+	g.jobs = append(g.jobs, job)
+	g.notify()
+
+	return job.key
+}
+
+// Wait for the result of the job `key` and return the last command sent by
+// the worker: a completion, a BPMN error or a failure without retries left.
+// The test fails if there is no result within `WaitTimeout`.
+func (g *Gateway) Wait(t testing.TB, key int64) Command {
+	t.Helper()
+
+	timeout := time.After(WaitTimeout)
+	for {
+		g.mu.Lock()
+		var result *Command
+		if job := g.job(key); job != nil && job.finished {
+			result = &job.commands[len(job.commands)-1]
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		if result != nil {
+			return *result
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("job %d has not been completed in %s", key, WaitTimeout)
+		}
+	}
+}
+
+// Wait for a message `name` published after the first `skip` ones with the
+// same name and return it. A job publishes its message after completing, so
+// `Wait` can return before it's sent.
+func (g *Gateway) WaitMessage(t testing.TB, name string, skip int) Message {
+	t.Helper()
+
+	timeout := time.After(WaitTimeout)
+	for {
+		g.mu.Lock()
+		var found []Message
+		for _, message := range g.messages {
+			if message.Name == name {
+				found = append(found, message)
+			}
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		if len(found) > skip {
+			return found[skip]
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("message `%s` has not been published in %s", name, WaitTimeout)
+		}
+	}
+}
+
+// Returns every command sent for the job `key`, including the failures which
+// have been retried, in order
+func (g *Gateway) Commands(key int64) []Command {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if job := g.job(key); job != nil {
+		return append([]Command{}, job.commands...)
+	}
+	return nil
+}
+
+// Queue a job of `jobType` for the instance `instanceKey` and wait for its
+// result
+func (g *Gateway) Step(t testing.TB, instanceKey int64, jobType string) Command {
+	t.Helper()
+
+	return g.Wait(t, g.Job(instanceKey, jobType))
+}
+
+// Merge `variables` into the instance `instanceKey`, e.g. the payload of a
+// message caught by the process
+func (g *Gateway) MergeVariables(instanceKey int64, variables map[string]interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if instance, ok := g.instances[instanceKey]; ok {
+		merge(instance.Variables, variables)
+	}
+}
+
+// Returns a copy of the instance `key`
+func (g *Gateway) Instance(key int64) (Instance, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instance, ok := g.instances[key]
+	if !ok {
+		return Instance{}, false
+	}
+
+	result := *instance
+	result.Variables = map[string]interface{}{}
+	merge(result.Variables, instance.Variables)
+
+	return result, true
+}
+
+// Returns the instances, in no particular order
+func (g *Gateway) Instances() []Instance {
+	g.mu.Lock()
+	keys := make([]int64, 0, len(g.instances))
+	for key := range g.instances {
+		keys = append(keys, key)
+	}
+	g.mu.Unlock()
+
+	result := make([]Instance, 0, len(keys))
+	for _, key := range keys {
+		instance, _ := g.Instance(key)
+		result = append(result, instance)
+	}
+
+	return result
+}
+
+// Returns the published messages, in order
+func (g *Gateway) Messages() []Message {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]Message{}, g.messages...)
+}
+
+// Returns the names of the deployed resources, in order
+func (g *Gateway) Resources() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]string{}, g.resources...)
+}
+
+func (g *Gateway) Topology(context.Context, *pb.TopologyRequest) (*pb.TopologyResponse, error) {
+	host, _, _ := net.SplitHostPort(g.Address())
+
+	return &pb.TopologyResponse{
+		Brokers: []*pb.BrokerInfo{{
+			Host: host,
+			Partitions: []*pb.Partition{{
+				PartitionId: 1,
+				Role:        pb.Partition_LEADER,
+				Health:      pb.Partition_HEALTHY,
+			}},
+		}},
+		ClusterSize:       1,
+		PartitionsCount:   1,
+		ReplicationFactor: 1,
+		GatewayVersion:    "jobtest",
+	}, nil
+}
+
+func (g *Gateway) DeployResource(_ context.Context, in *pb.DeployResourceRequest) (*pb.DeployResourceResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	response := &pb.DeployResourceResponse{Key: NextKey()}
+
+	for _, resource := range in.GetResources() {
+		g.resources = append(g.resources, resource.GetName())
+
+		if !strings.HasSuffix(resource.GetName(), ".bpmn") {
+			continue
+		}
+
+		processIds, err := executableProcesses(resource.GetContent())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid resource `%s`: %s", resource.GetName(), err.Error())
+		}
+
+		for _, processId := range processIds {
+			g.processes[processId]++
+			response.Deployments = append(response.Deployments, &pb.Deployment{
+				Metadata: &pb.Deployment_Process{Process: &pb.ProcessMetadata{
+					BpmnProcessId:        processId,
+					Version:              g.processes[processId],
+					ProcessDefinitionKey: NextKey(),
+					ResourceName:         resource.GetName(),
+				}},
+			})
+		}
+	}
+
+	return response, nil
+}
+
+func (g *Gateway) CreateProcessInstance(_ context.Context, in *pb.CreateProcessInstanceRequest) (*pb.CreateProcessInstanceResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	version, ok := g.processes[in.GetBpmnProcessId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "process `%s` is not deployed", in.GetBpmnProcessId())
+	}
+
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	instance := g.createInstance(in.GetBpmnProcessId(), variables)
+
+	return &pb.CreateProcessInstanceResponse{
+		BpmnProcessId:      instance.BpmnProcessId,
+		Version:            version,
+		ProcessInstanceKey: instance.Key,
+	}, nil
+}
+
+func (g *Gateway) CancelProcessInstance(_ context.Context, in *pb.CancelProcessInstanceRequest) (*pb.CancelProcessInstanceResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instance, ok := g.instances[in.GetProcessInstanceKey()]
+	if !ok || !instance.Active {
+		return nil, status.Errorf(codes.NotFound, "process instance %d not found", in.GetProcessInstanceKey())
+	}
+
+	instance.Active = false
+	g.notify()
+
+	return &pb.CancelProcessInstanceResponse{}, nil
+}
+
+func (g *Gateway) SetVariables(_ context.Context, in *pb.SetVariablesRequest) (*pb.SetVariablesResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instance, ok := g.instances[in.GetElementInstanceKey()]
+	if !ok || !instance.Active {
+		return nil, status.Errorf(codes.NotFound, "element instance %d not found", in.GetElementInstanceKey())
+	}
+
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	merge(instance.Variables, variables)
+
+	return &pb.SetVariablesResponse{Key: NextKey()}, nil
+}
+
+func (g *Gateway) PublishMessage(_ context.Context, in *pb.PublishMessageRequest) (*pb.PublishMessageResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.messages = append(g.messages, Message{
+		Name:           in.GetName(),
+		CorrelationKey: in.GetCorrelationKey(),
+		MessageId:      in.GetMessageId(),
+		TimeToLive:     time.Duration(in.GetTimeToLive()) * time.Millisecond,
+		Variables:      variables,
+	})
+	g.notify()
+
+	return &pb.PublishMessageResponse{Key: NextKey()}, nil
+}
+
+// Activate the queued jobs of the requested type. Like the real gateway, the
+// request is kept open until a job is queued or its timeout expires.
+func (g *Gateway) ActivateJobs(in *pb.ActivateJobsRequest, stream pb.Gateway_ActivateJobsServer) error {
+	timeout := time.Duration(in.GetRequestTimeout()) * time.Millisecond
+	if timeout <= 0 || timeout > PollTimeout {
+		timeout = PollTimeout
+	}
+	deadline := time.After(timeout)
+
+	for {
+		g.mu.Lock()
+		jobs, retry := g.activate(in)
+		changed := g.changed
+		g.mu.Unlock()
+
+		if len(jobs) > 0 {
+			return stream.Send(&pb.ActivateJobsResponse{Jobs: jobs})
+		}
+
+		// Wake up when the backoff of a failed job expires
+		var retryAfter <-chan time.Time
+		if retry > 0 {
+			retryAfter = time.After(retry)
+		}
+
+		select {
+		case <-changed:
+		case <-retryAfter:
+		case <-deadline:
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (g *Gateway) StreamActivatedJobs(*pb.StreamActivatedJobsRequest, pb.Gateway_StreamActivatedJobsServer) error {
+	return status.Error(codes.Unimplemented, "job streaming is not supported by the fake gateway")
+}
+
+func (g *Gateway) CompleteJob(_ context.Context, in *pb.CompleteJobRequest) (*pb.CompleteJobResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = g.finish(Command{Kind: Complete, JobKey: in.GetJobKey(), Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CompleteJobResponse{}, nil
+}
+
+func (g *Gateway) FailJob(_ context.Context, in *pb.FailJobRequest) (*pb.FailJobResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = g.finish(Command{
+		Kind:         Fail,
+		JobKey:       in.GetJobKey(),
+		Variables:    variables,
+		Retries:      in.GetRetries(),
+		RetryBackoff: time.Duration(in.GetRetryBackOff()) * time.Millisecond,
+		ErrorMessage: in.GetErrorMessage(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.FailJobResponse{}, nil
+}
+
+func (g *Gateway) ThrowError(_ context.Context, in *pb.ThrowErrorRequest) (*pb.ThrowErrorResponse, error) {
+	variables, err := decodeVariables(in.GetVariables())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = g.finish(Command{
+		Kind:         ThrowError,
+		JobKey:       in.GetJobKey(),
+		Variables:    variables,
+		ErrorCode:    in.GetErrorCode(),
+		ErrorMessage: in.GetErrorMessage(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ThrowErrorResponse{}, nil
+}
+
+// Create an instance. The caller must hold the lock.
+func (g *Gateway) createInstance(processId string, variables map[string]interface{}) *Instance {
+	instance := &Instance{
+		Key:           NextKey(),
+		BpmnProcessId: processId,
+		Variables:     map[string]interface{}{},
+		Active:        true,
+	}
+	merge(instance.Variables, variables)
+
+	g.instances[instance.Key] = instance
+	g.notify()
+
+	return instance
+}
+
+// Mark the queued jobs matching `in` as activated and return them, with the
+// time left before a failed job can be activated again, 0 if none is waiting.
+// The caller must hold the lock.
+func (g *Gateway) activate(in *pb.ActivateJobsRequest) ([]*pb.ActivatedJob, time.Duration) {
+	var result []*pb.ActivatedJob
+	var retry time.Duration
+	now := time.Now()
+
+	for _, job := range g.jobs {
+		if int32(len(result)) >= in.GetMaxJobsToActivate() {
+			break
+		}
+		if job.activated || job.finished || job.jobType != in.GetType() {
+			continue
+		}
+		if wait := job.availableAt.Sub(now); wait > 0 {
+			if retry == 0 || wait < retry {
+				retry = wait
+			}
+			continue
+		}
+
+		variables := job.variables
+		if fetch := in.GetFetchVariable(); len(fetch) > 0 {
+			variables = map[string]interface{}{}
+			for _, name := range fetch {
+				if value, ok := job.variables[name]; ok {
+					variables[name] = value
+				}
+			}
+		}
+
+		job.activated = true
+		result = append(result, &pb.ActivatedJob{
+			Key:                job.key,
+			Type:               job.jobType,
+			ProcessInstanceKey: job.instanceKey,
+			BpmnProcessId:      g.processId(job.instanceKey),
+			ElementId:          job.jobType,
+			ElementInstanceKey: NextKey(),
+			CustomHeaders:      "{}",
+			Worker:             in.GetWorker(),
+			Retries:            job.retries,
+			Deadline:           time.Now().Add(time.Duration(in.GetTimeout()) * time.Millisecond).UnixMilli(),
+			Variables:          mustMarshal(variables),
+		})
+	}
+
+	return result, retry
+}
+
+// Returns the job `key`, nil if it doesn't exist. The caller must hold the
+// lock.
+func (g *Gateway) job(key int64) *fakeJob {
+	for _, job := range g.jobs {
+		if job.key == key {
+			return job
+		}
+	}
+	return nil
+}
+
+// Returns the BPMN process id of an instance. The caller must hold the lock.
+func (g *Gateway) processId(instanceKey int64) string {
+	if instance, ok := g.instances[instanceKey]; ok {
+		return instance.BpmnProcessId
+	}
+	return ""
+}
+
+// Record the command sent for an activated job. The variables of a completed
+// job are merged into its instance, while a failed job with retries left is
+// queued again after its backoff.
+func (g *Gateway) finish(command Command) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	job := g.job(command.JobKey)
+	if job == nil {
+		return status.Errorf(codes.NotFound, "job %d not found", command.JobKey)
+	}
+	if !job.activated || job.finished {
+		return status.Errorf(codes.FailedPrecondition, "job %d is not activated", job.key)
+	}
+
+	job.commands = append(job.commands, command)
+	job.activated = false
+
+	if command.Kind == Fail && command.Retries > 0 {
+		backoff := command.RetryBackoff
+		if backoff > MaxRetryBackoff {
+			backoff = MaxRetryBackoff
+		}
+		job.retries = command.Retries
+		job.availableAt = time.Now().Add(backoff)
+	} else {
+		job.finished = true
+		if instance, ok := g.instances[job.instanceKey]; ok && command.Kind == Complete {
+			merge(instance.Variables, command.Variables)
+		}
+	}
+	g.notify()
+
+	return nil
+}
+
+// Wake up everyone waiting for a change. The caller must hold the lock.
+func (g *Gateway) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Copy `source` into `target`
+func merge(target map[string]interface{}, source map[string]interface{}) {
+	for name, value := range source {
+		target[name] = value
+	}
+}
+
+// Returns the ids of the executable processes of a BPMN resource
+func executableProcesses(content []byte) ([]string, error) {
+	var result []string
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "process" {
+			continue
+		}
+
+		var id, executable string
+		for _, attr := range element.Attr {
+			switch attr.Name.Local {
+			case "id":
+				id = attr.Value
+			case "isExecutable":
+				executable = attr.Value
+			}
+		}
+		if executable == "true" {
+			result = append(result, id)
+		}
+	}
+}
+
+// Credentials provider which doesn't add any header
+type noCredentials struct{}
+
+func (noCredentials) ApplyCredentials(context.Context, map[string]string) error {
+	return nil
+}
+
+func (noCredentials) ShouldRetryRequest(context.Context, error) bool {
+	return false
+}
@@ -0,0 +1,94 @@
+package jobtest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Open a worker for `job` on the gateway, closed at the end of the test
+func handle(t *testing.T, gateway *Gateway, job acmejob.Job) {
+	t.Helper()
+
+	worker := job.Handle(gateway.Client(t))
+	t.Cleanup(worker.Close)
+}
+
+func TestGatewayRetriesFailedJobs(t *testing.T) {
+	gateway := NewGateway(t)
+
+	var calls atomic.Int32
+	handle(t, gateway, acmejob.Job{
+		Name: "ST_Flaky",
+		Handler: func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			if calls.Add(1) < 3 {
+				return nil, errors.New("timeout")
+			}
+			return map[string]interface{}{"done": true}, nil
+		},
+		TaskRetries: 3,
+	})
+
+	instance := gateway.CreateInstance("Process_Test", nil)
+	key := gateway.JobWithRetries(instance, "ST_Flaky", 3)
+
+	result := gateway.Wait(t, key)
+	if result.Kind != Complete {
+		t.Fatalf("result = %+v, want a completion", result)
+	}
+
+	commands := gateway.Commands(key)
+	if len(commands) != 3 {
+		t.Fatalf("sent %d commands, want 2 failures and a completion: %+v", len(commands), commands)
+	}
+	for i, retries := range []int32{2, 1} {
+		if commands[i].Kind != Fail || commands[i].Retries != retries {
+			t.Errorf("command %d = %+v, want a failure with %d retries", i, commands[i], retries)
+		}
+	}
+	if commands[0].RetryBackoff != acmejob.DefaultRetryPolicy.InitialBackoff || commands[1].RetryBackoff <= commands[0].RetryBackoff {
+		t.Errorf("backoffs = %s, %s, want an exponential backoff from %s", commands[0].RetryBackoff, commands[1].RetryBackoff, acmejob.DefaultRetryPolicy.InitialBackoff)
+	}
+
+	state, _ := gateway.Instance(instance)
+	AssertVariable(t, state.Variables, "done", true)
+}
+
+func TestGatewayCancelsAfterLastRetry(t *testing.T) {
+	gateway := NewGateway(t)
+	handle(t, gateway, acmejob.Job{
+		Name: "ST_Failing",
+		Handler: func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			return nil, errors.New("timeout")
+		},
+		Retry: &acmejob.RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1},
+	})
+
+	instance := gateway.CreateInstance("Process_Test", nil)
+	key := gateway.Job(instance, "ST_Failing")
+
+	result := gateway.Wait(t, key)
+	if result.Kind != Fail || result.Retries != 0 {
+		t.Fatalf("result = %+v, want a failure without retries", result)
+	}
+	if commands := gateway.Commands(key); len(commands) != 2 {
+		t.Errorf("sent %d commands, want 2 failures: %+v", len(commands), commands)
+	}
+
+	// The instance is canceled after the failure is sent
+	deadline := time.Now().Add(WaitTimeout)
+	for {
+		if state, _ := gateway.Instance(instance); !state.Active {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("instance not canceled after the last retry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
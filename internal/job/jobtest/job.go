@@ -0,0 +1,135 @@
+package jobtest
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+)
+
+// Last generated key. Zeebe keys start from the partition id shifted by 51
+// bits, so the fake ones look alike.
+var lastKey atomic.Int64
+
+func init() {
+	lastKey.Store(1 << 51)
+}
+
+// Returns a new unique key for jobs and process instances
+func NextKey() int64 {
+	return lastKey.Add(1)
+}
+
+// Builder of activated jobs
+type JobBuilder struct {
+	job       *pb.ActivatedJob
+	variables map[string]interface{}
+	headers   map[string]string
+}
+
+// Returns a builder for an activated job of type `jobType`, with new keys and
+// 3 retries
+func NewJob(jobType string) *JobBuilder {
+	return &JobBuilder{
+		job: &pb.ActivatedJob{
+			Key:                NextKey(),
+			Type:               jobType,
+			ProcessInstanceKey: NextKey(),
+			ElementInstanceKey: NextKey(),
+			BpmnProcessId:      "Process_Test",
+			ElementId:          jobType,
+			Worker:             "jobtest",
+			Retries:            3,
+			Deadline:           time.Now().Add(5 * time.Minute).UnixMilli(),
+		},
+		variables: map[string]interface{}{},
+		headers:   map[string]string{},
+	}
+}
+
+// Set every variable of `variables`
+func (b *JobBuilder) Variables(variables map[string]interface{}) *JobBuilder {
+	for name, value := range variables {
+		b.variables[name] = value
+	}
+	return b
+}
+
+// Set the variable `name`
+func (b *JobBuilder) Variable(name string, value interface{}) *JobBuilder {
+	b.variables[name] = value
+	return b
+}
+
+// Set the custom header `name`
+func (b *JobBuilder) Header(name string, value string) *JobBuilder {
+	b.headers[name] = value
+	return b
+}
+
+func (b *JobBuilder) Key(key int64) *JobBuilder {
+	b.job.Key = key
+	return b
+}
+
+func (b *JobBuilder) ProcessInstanceKey(key int64) *JobBuilder {
+	b.job.ProcessInstanceKey = key
+	return b
+}
+
+func (b *JobBuilder) ElementId(id string) *JobBuilder {
+	b.job.ElementId = id
+	return b
+}
+
+func (b *JobBuilder) Retries(retries int32) *JobBuilder {
+	b.job.Retries = retries
+	return b
+}
+
+// Returns the activated job. The variables are encoded as JSON, so the
+// handler decodes numbers as float64 like with a real broker.
+func (b *JobBuilder) Build() entities.Job {
+	job := &pb.ActivatedJob{
+		Key:                      b.job.Key,
+		Type:                     b.job.Type,
+		ProcessInstanceKey:       b.job.ProcessInstanceKey,
+		BpmnProcessId:            b.job.BpmnProcessId,
+		ProcessDefinitionVersion: b.job.ProcessDefinitionVersion,
+		ProcessDefinitionKey:     b.job.ProcessDefinitionKey,
+		ElementId:                b.job.ElementId,
+		ElementInstanceKey:       b.job.ElementInstanceKey,
+		Worker:                   b.job.Worker,
+		Retries:                  b.job.Retries,
+		Deadline:                 b.job.Deadline,
+		Variables:                mustMarshal(b.variables),
+		CustomHeaders:            mustMarshal(b.headers),
+	}
+
+	return entities.Job{ActivatedJob: job}
+}
+
+func mustMarshal(value interface{}) string {
+	content, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(content)
+}
+
+// Execute `job` for `activated` with a new fake job client and return it with
+// the recorded commands
+func Execute(t testing.TB, job acmejob.Job, activated entities.Job) *JobClient {
+	t.Helper()
+
+	client := NewJobClient()
+	job.Execute(context.Background(), client, activated)
+
+	return client
+}
@@ -0,0 +1,150 @@
+package jobtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Returns a job whose handler always returns `err`
+func failingJob(err error, retry *acmejob.RetryPolicy) acmejob.Job {
+	return acmejob.Job{
+		Name: "ST_Failing",
+		Handler: func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			return nil, err
+		},
+		Retry: retry,
+	}
+}
+
+func TestJobClientRecordsFailures(t *testing.T) {
+	policy := &acmejob.RetryPolicy{MaxRetries: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}
+	job := failingJob(errors.New("timeout"), policy)
+
+	first := NewJob("ST_Failing").Retries(3).Build()
+	client := Execute(t, job, first)
+
+	command := AssertFailed(t, client, first, 2)
+	if command.RetryBackoff != time.Second {
+		t.Errorf("first backoff = %s, want 1s", command.RetryBackoff)
+	}
+	if command.ErrorMessage != "timeout" {
+		t.Errorf("error message = %q, want %q", command.ErrorMessage, "timeout")
+	}
+
+	second := NewJob("ST_Failing").Retries(2).Build()
+	command = AssertFailed(t, Execute(t, job, second), second, 1)
+	if command.RetryBackoff != 2*time.Second {
+		t.Errorf("second backoff = %s, want 2s", command.RetryBackoff)
+	}
+}
+
+func TestJobClientRecordsFatalFailures(t *testing.T) {
+	activated := NewJob("ST_Failing").Retries(3).Build()
+	client := Execute(t, failingJob(acmejob.Fatal(errors.New("invalid")), nil), activated)
+
+	command := AssertFailed(t, client, activated, 0)
+	if command.RetryBackoff != 0 {
+		t.Errorf("backoff = %s, want none for a fatal error", command.RetryBackoff)
+	}
+}
+
+func TestJobClientRecordsBPMNErrors(t *testing.T) {
+	err := acmejob.NewBPMNError("EB_Test", "Not valid", map[string]interface{}{"offer_id": nil})
+
+	activated := NewJob("ST_Failing").Build()
+	command := AssertThrown(t, Execute(t, failingJob(err, nil), activated), activated, "EB_Test")
+
+	if command.ErrorMessage != "Not valid" {
+		t.Errorf("error message = %q, want %q", command.ErrorMessage, "Not valid")
+	}
+	if value, ok := command.Variables["offer_id"]; !ok || value != nil {
+		t.Errorf("variables = %v, want offer_id = nil", command.Variables)
+	}
+}
+
+func TestJobClientRecordsCompletions(t *testing.T) {
+	job := acmejob.Job{
+		Name: "ST_Completing",
+		Handler: func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			variables, err := job.GetVariablesAsMap()
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"id": variables["id"]}, nil
+		},
+	}
+
+	activated := NewJob("ST_Completing").Variable("id", 7).Build()
+	client := Execute(t, job, activated)
+
+	variables := AssertCompleted(t, client, activated)
+	if variables["id"] != float64(7) {
+		t.Errorf("id = %#v, want it decoded as float64", variables["id"])
+	}
+
+	client.Reset()
+	if commands := client.Commands(); len(commands) != 0 {
+		t.Errorf("commands after reset = %v, want none", commands)
+	}
+}
+
+func TestJobClientErr(t *testing.T) {
+	client := NewJobClient()
+	client.Err = errors.New("gateway unavailable")
+
+	job := failingJob(errors.New("timeout"), nil)
+	activated := NewJob("ST_Failing").Build()
+	job.Execute(context.Background(), client, activated)
+
+	if commands := client.CommandsFor(activated.GetKey()); len(commands) != 0 {
+		t.Errorf("commands = %v, want none when the client fails", commands)
+	}
+}
+
+func TestAssertVariableNormalizesNumbers(t *testing.T) {
+	type offer struct {
+		Id    uint    `json:"id"`
+		Price float64 `json:"price"`
+	}
+
+	variables := map[string]interface{}{
+		"id":       float64(3),
+		"journeys": []interface{}{float64(1), float64(2)},
+		"offer":    map[string]interface{}{"id": float64(4), "price": 10.5},
+		"missing":  nil,
+	}
+
+	AssertVariable(t, variables, "id", 3)
+	AssertVariable(t, variables, "id", uint(3))
+	AssertVariable(t, variables, "id", int64(3))
+	AssertVariable(t, variables, "journeys", []uint{1, 2})
+	AssertVariable(t, variables, "offer", offer{Id: 4, Price: 10.5})
+	AssertVariable(t, variables, "missing", nil)
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  interface{}
+		equal bool
+	}{
+		{name: "int and float64", a: 1, b: float64(1), equal: true},
+		{name: "uint slice and decoded slice", a: []uint{1}, b: []interface{}{float64(1)}, equal: true},
+		{name: "number and string", a: 1, b: "1", equal: false},
+		{name: "different numbers", a: 1, b: 1.5, equal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reflect.DeepEqual(normalize(tt.a), normalize(tt.b)); got != tt.equal {
+				t.Errorf("normalize(%#v) == normalize(%#v) is %t, want %t", tt.a, tt.b, got, tt.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Function which wraps a handler to add a cross-cutting concern, e.g. logging
+// or panic recovery.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Wrap `handler` with `middlewares`. The first middleware is the outermost, so
+// it runs first.
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Middlewares used by all the jobs
+func DefaultMiddlewares() []Middleware {
+	return []Middleware{Logger(), Metrics(), Recover()}
+}
+
+// Error of a handler which panicked. It's retried like any other error, but
+// the process instance is not canceled when no retries are left: the incident
+// stays open, so the job can be retried from Operate once the bug is fixed.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Handler panicked: %v", e.Value)
+}
+
+// Returns true if `err` comes from a handler which panicked
+func IsPanic(err error) bool {
+	var panicErr *PanicError
+	return errors.As(err, &panicErr)
+}
+
+// Recover from a panic in the handler, e.g. a bad type assertion on a variable,
+// and return it as a `PanicError` so the job fails instead of crashing the
+// worker.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, job entities.Job) (variables map[string]interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logging.FromContext(ctx).Error("Handler panicked", "panic", r, "stack", string(debug.Stack()))
+					variables = nil
+					err = &PanicError{Value: r}
+				}
+			}()
+
+			return next(ctx, job)
+		}
+	}
+}
+
+// Log the result of the handler and its duration, with the job fields of the
+// `ctx` logger.
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			start := time.Now()
+			variables, err := next(ctx, job)
+
+			logger := logging.FromContext(ctx).With("duration", time.Since(start))
+
+			var bpmnErr *BPMNError
+			switch {
+			case errors.As(err, &bpmnErr):
+				logger.Warn("Thrown BPMN error", "code", bpmnErr.Code, "err", bpmnErr.Message)
+			case err != nil:
+				logger.Error("Failed to complete job", "err", err)
+			default:
+				logger.Info("Successfully completed job")
+			}
+
+			return variables, err
+		}
+	}
+}
+
+// Count activations, completions and failures of the handler and observe its
+// duration.
+func Metrics() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			metrics.JobsActivated.WithLabelValues(job.GetType()).Inc()
+
+			start := time.Now()
+			variables, err := next(ctx, job)
+			metrics.JobDuration.WithLabelValues(job.GetType()).Observe(time.Since(start).Seconds())
+
+			if err != nil {
+				metrics.JobsFailed.WithLabelValues(job.GetType()).Inc()
+			} else {
+				metrics.JobsCompleted.WithLabelValues(job.GetType()).Inc()
+			}
+
+			return variables, err
+		}
+	}
+}
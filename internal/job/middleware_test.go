@@ -0,0 +1,69 @@
+package job_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+func TestRecoverRetriesPanics(t *testing.T) {
+	job := acmejob.Job{
+		Name: "ST_Panic",
+		Handler: func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			var variables map[string]interface{}
+			return map[string]interface{}{"id": variables["id"].(float64)}, nil
+		},
+		Middlewares: []acmejob.Middleware{acmejob.Recover()},
+	}
+
+	activated := jobtest.NewJob("ST_Panic").Retries(3).Build()
+	client := jobtest.NewJobClient()
+	_, err := job.Execute(context.Background(), client, activated)
+
+	if !acmejob.IsPanic(err) {
+		t.Fatalf("expected a `PanicError`, got %v", err)
+	}
+	if acmejob.IsFatal(err) {
+		t.Fatal("a panic must not be fatal")
+	}
+
+	command := jobtest.AssertFailed(t, client, activated, 2)
+	if command.RetryBackoff <= 0 {
+		t.Errorf("expected a retry backoff, got %s", command.RetryBackoff)
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	middleware := func(name string) acmejob.Middleware {
+		return func(next acmejob.HandlerFunc) acmejob.HandlerFunc {
+			return func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+				calls = append(calls, name)
+				return next(ctx, job)
+			}
+		}
+	}
+
+	handler := acmejob.Chain(func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+		calls = append(calls, "handler")
+		return nil, errors.New("failed")
+	}, middleware("outer"), middleware("inner"))
+
+	if _, err := handler(context.Background(), jobtest.NewJob("ST_Chain").Build()); err == nil {
+		t.Fatal("expected the handler error")
+	}
+
+	want := []string{"outer", "inner", "handler"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", calls, want)
+		}
+	}
+}
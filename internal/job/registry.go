@@ -0,0 +1,202 @@
+package job
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	bpmnNamespace  = "http://www.omg.org/spec/BPMN/20100524/MODEL"
+	zeebeNamespace = "http://camunda.org/schema/zeebe/1.0"
+)
+
+// Task types and message names defined in a BPMN file
+type BPMNModel struct {
+	TaskTypes []string
+	Messages  []string
+
+	// Retries of the task types which set the `retries` attribute
+	Retries map[string]int32
+}
+
+// Parse the BPMN file at `path` and list every `zeebe:taskDefinition` type and
+// every `bpmn:message` name, without duplicates.
+func ParseBPMN(path string) (*BPMNModel, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	taskTypes := map[string]bool{}
+	messages := map[string]bool{}
+	retries := map[string]int32{}
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid BPMN file `%s`: %s", path, err.Error())
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case element.Name.Space == zeebeNamespace && element.Name.Local == "taskDefinition":
+			if value := attribute(element, "type"); len(value) > 0 {
+				taskTypes[value] = true
+
+				// A static number, optionally written as a FEEL expression
+				raw := strings.TrimSpace(strings.TrimPrefix(attribute(element, "retries"), "="))
+				if n, err := strconv.ParseInt(raw, 10, 32); err == nil && n > 0 {
+					retries[value] = int32(n)
+				}
+			}
+		case element.Name.Space == bpmnNamespace && element.Name.Local == "message":
+			if value := attribute(element, "name"); len(value) > 0 {
+				messages[value] = true
+			}
+		}
+	}
+
+	return &BPMNModel{TaskTypes: keys(taskTypes), Messages: keys(messages), Retries: retries}, nil
+}
+
+// Check that every task type of the model has a registered job and that every
+// message sent by a job exists in the model.
+// Jobs which are not referenced by the model are only returned as warnings,
+// because they don't block any process.
+func (m *BPMNModel) Validate(jobs []Job) (errs []error, warnings []error) {
+	registered := map[string]bool{}
+	for _, job := range jobs {
+		registered[job.Name] = true
+	}
+
+	taskTypes := map[string]bool{}
+	for _, taskType := range m.TaskTypes {
+		taskTypes[taskType] = true
+		if !registered[taskType] {
+			errs = append(errs, fmt.Errorf("Task type `%s` has no registered job", taskType))
+		}
+	}
+
+	messages := map[string]bool{}
+	for _, message := range m.Messages {
+		messages[message] = true
+	}
+
+	for _, job := range jobs {
+		if !taskTypes[job.Name] {
+			warnings = append(warnings, fmt.Errorf("Job `%s` is not used by any task", job.Name))
+		}
+
+		if job.Message != nil && !messages[job.Message.Name] {
+			errs = append(errs, fmt.Errorf("Message `%s` sent by job `%s` does not exist", job.Message.Name, job.Name))
+		}
+	}
+
+	return errs, warnings
+}
+
+// Set the `TaskRetries` of `jobs` from the retries of their tasks
+func (m *BPMNModel) ApplyRetries(jobs []Job) {
+	for i := range jobs {
+		if retries, ok := m.Retries[jobs[i].Name]; ok {
+			jobs[i].TaskRetries = retries
+		}
+	}
+}
+
+// Parse the BPMN files of `resources`, merging their models. Other resources,
+// like forms and decisions, are skipped.
+func ParseBPMNFiles(resources []string) (*BPMNModel, error) {
+	taskTypes := map[string]bool{}
+	messages := map[string]bool{}
+	retries := map[string]int32{}
+
+	for _, resource := range resources {
+		if filepath.Ext(resource) != ".bpmn" {
+			continue
+		}
+
+		model, err := ParseBPMN(resource)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, taskType := range model.TaskTypes {
+			taskTypes[taskType] = true
+		}
+		for _, message := range model.Messages {
+			messages[message] = true
+		}
+		for taskType, n := range model.Retries {
+			retries[taskType] = n
+		}
+	}
+
+	return &BPMNModel{TaskTypes: keys(taskTypes), Messages: keys(messages), Retries: retries}, nil
+}
+
+// Parse the BPMN files of `resources`, validate `jobs` against them and set
+// their `TaskRetries`. In `lenient` mode the errors are returned as warnings,
+// so the caller can start anyway.
+func ValidateRegistry(resources []string, jobs []Job, lenient bool) (warnings []error, err error) {
+	model, err := ParseBPMNFiles(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ApplyRetries(jobs)
+
+	errs, warnings := model.Validate(jobs)
+	if len(errs) == 0 {
+		return warnings, nil
+	}
+
+	if lenient {
+		return append(errs, warnings...), nil
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+
+	return warnings, fmt.Errorf("Jobs registry does not match `%s`: %s", strings.Join(resources, ", "), strings.Join(messages, "; "))
+}
+
+// Returns the value of the attribute `name` of `element`
+func attribute(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// Returns the sorted keys of a set
+func keys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+
+	return result
+}
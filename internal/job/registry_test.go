@@ -0,0 +1,83 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const bpmnHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:zeebe="http://camunda.org/schema/zeebe/1.0">
+`
+
+// Write a BPMN file in a temporary directory and returns its path
+func writeBPMN(t *testing.T, name string, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(bpmnHeader+body+"</bpmn:definitions>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestValidateRegistryReadsEveryResource(t *testing.T) {
+	user := writeBPMN(t, "user.bpmn", `
+  <bpmn:process id="Process_User" isExecutable="true">
+    <bpmn:serviceTask id="A"><bpmn:extensionElements><zeebe:taskDefinition type="ST_User" retries="5" /></bpmn:extensionElements></bpmn:serviceTask>
+  </bpmn:process>
+  <bpmn:message id="M1" name="CM_User" />
+`)
+	airline := writeBPMN(t, "airline.bpmn", `
+  <bpmn:process id="Process_Airline" isExecutable="true">
+    <bpmn:serviceTask id="B"><bpmn:extensionElements><zeebe:taskDefinition type="ST_Airline" /></bpmn:extensionElements></bpmn:serviceTask>
+  </bpmn:process>
+`)
+
+	jobs := []Job{
+		{Name: "ST_User", Message: &MessageCommand{Name: "CM_User"}},
+		{Name: "ST_Airline"},
+	}
+
+	warnings, err := ValidateRegistry([]string{user, airline, "express_interest.form"}, jobs, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	if jobs[0].TaskRetries != 5 {
+		t.Errorf("TaskRetries = %d, want 5", jobs[0].TaskRetries)
+	}
+
+	if _, err := ValidateRegistry([]string{user}, jobs[:1], false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err = ValidateRegistry([]string{user, airline}, jobs[:1], false)
+	if err == nil || !strings.Contains(err.Error(), "Task type `ST_Airline` has no registered job") {
+		t.Errorf("error = %v, want the missing `ST_Airline` job", err)
+	}
+}
+
+func TestValidateRegistryLenient(t *testing.T) {
+	path := writeBPMN(t, "process.bpmn", `
+  <bpmn:process id="Process_User" isExecutable="true">
+    <bpmn:serviceTask id="A"><bpmn:extensionElements><zeebe:taskDefinition type="TEST" /></bpmn:extensionElements></bpmn:serviceTask>
+  </bpmn:process>
+`)
+	jobs := []Job{{Name: "ST_Unused", Message: &MessageCommand{Name: "CM_Unknown"}}}
+
+	if _, err := ValidateRegistry([]string{path}, jobs, false); err == nil {
+		t.Fatal("expected an error in strict mode")
+	}
+
+	warnings, err := ValidateRegistry([]string{path}, jobs, true)
+	if err != nil {
+		t.Fatalf("unexpected error in lenient mode: %s", err)
+	}
+	if len(warnings) != 3 {
+		t.Errorf("warnings = %v, want the missing job, the unknown message and the unused job", warnings)
+	}
+}
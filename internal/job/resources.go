@@ -0,0 +1,132 @@
+package job
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acme-sky/workers/internal/config"
+	"github.com/charmbracelet/log"
+)
+
+// Extensions of the resources which can be deployed on Zeebe
+var resourceExtensions = []string{".bpmn", ".dmn", ".form"}
+
+// Returns the resources matched by `BPMN_RESOURCES`, or `BPMN_FILE` if it's
+// not set
+func DeployedResources() ([]string, error) {
+	conf, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := conf.String("bpmn.resources")
+	if len(patterns) == 0 {
+		patterns = conf.String("bpmn.file")
+	}
+
+	return FindResources(patterns)
+}
+
+// Returns the resources to deploy from a comma separated list of files,
+// directories and globs, e.g. `bpmn/` or `bpmn/*.bpmn,bpmn/*.form`.
+// Directories are not read recursively. BPMN files without an executable
+// process, like choreographies, are skipped because Zeebe rejects them.
+func FindResources(patterns string) ([]string, error) {
+	found := map[string]bool{}
+
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid resource pattern `%s`: %s", pattern, err.Error())
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No resource found for `%s`", pattern)
+		}
+
+		for _, match := range matches {
+			if isResource(match) {
+				found[match] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		if filepath.Ext(path) == ".bpmn" {
+			executable, err := hasExecutableProcess(path)
+			if err != nil {
+				return nil, err
+			}
+			if !executable {
+				log.Warnf("Resource `%s` has no executable process, skipped", path)
+				continue
+			}
+		}
+
+		paths = append(paths, path)
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("No resource to deploy")
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Returns true if `path` is a file with a deployable extension
+func isResource(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	for _, extension := range resourceExtensions {
+		if filepath.Ext(path) == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if the BPMN file at `path` defines at least one executable
+// process
+func hasExecutableProcess(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Invalid BPMN file `%s`: %s", path, err.Error())
+		}
+
+		element, ok := token.(xml.StartElement)
+		if ok && element.Name.Space == bpmnNamespace && element.Name.Local == "process" && attribute(element, "isExecutable") == "true" {
+			return true, nil
+		}
+	}
+}
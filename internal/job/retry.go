@@ -0,0 +1,128 @@
+package job
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Policy used by `failJob` to decide how many times and after how much time a
+// failed job must be retried.
+type RetryPolicy struct {
+	// Max number of retries for a job. The retries left on the job are never
+	// increased, so it can only lower the value defined in the BPMN task.
+	MaxRetries int32
+
+	// Backoff before the first retry
+	InitialBackoff time.Duration
+
+	// Upper limit for the backoff
+	MaxBackoff time.Duration
+
+	// Factor applied to the backoff for every retry
+	Multiplier float64
+
+	// Errors which must not be retried, compared using `errors.Is`
+	FatalErrors []error
+}
+
+// Policy used by jobs without an explicit one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     time.Minute,
+	Multiplier:     2,
+	FatalErrors:    []error{gorm.ErrRecordNotFound},
+}
+
+// Policy of the jobs whose handler is not idempotent, e.g. it books a flight
+// or creates an offer: the first failure raises an incident, so a retry can't
+// repeat the side effect.
+var NoRetryPolicy = RetryPolicy{}
+
+// Retries of a BPMN task without the `retries` attribute
+const defaultTaskRetries int32 = 3
+
+// Returns the retries left after a failure, given the `retries` left on the
+// activated job.
+func (p *RetryPolicy) retries(retries int32) int32 {
+	retries--
+	if retries > p.MaxRetries {
+		retries = p.MaxRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	return retries
+}
+
+// Returns the backoff before the next attempt when `retries` are left, for a
+// task which starts with `taskRetries`. The retries are capped to
+// `MaxRetries` on the first failure, so the attempts are counted from the
+// lower of the two.
+func (p *RetryPolicy) backoff(taskRetries int32, retries int32) time.Duration {
+	if taskRetries <= 0 {
+		taskRetries = defaultTaskRetries
+	}
+
+	initial := taskRetries
+	if initial > p.MaxRetries+1 {
+		initial = p.MaxRetries + 1
+	}
+
+	attempt := initial - retries
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+
+	return time.Duration(backoff)
+}
+
+// Returns true if `err` must not be retried by this policy
+func (p *RetryPolicy) isFatal(err error) bool {
+	if IsFatal(err) {
+		return true
+	}
+
+	for _, fatal := range p.FatalErrors {
+		if errors.Is(err, fatal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Error which makes a job fail without any retry, e.g. an invalid variable.
+// Any other error is considered transient, unless it is listed in the
+// `FatalErrors` of the job retry policy.
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap `err` as a fatal error
+func Fatal(err error) error {
+	return &FatalError{Err: err}
+}
+
+// Returns true if `err` must not be retried
+func IsFatal(err error) bool {
+	var fatal *FatalError
+	return errors.As(err, &fatal)
+}
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRetryPolicyRetries(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2}
+
+	tests := []struct {
+		retries int32
+		want    int32
+	}{
+		{retries: 5, want: 2},
+		{retries: 3, want: 2},
+		{retries: 2, want: 1},
+		{retries: 1, want: 0},
+		{retries: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := policy.retries(tt.retries); got != tt.want {
+			t.Errorf("retries(%d) = %d, want %d", tt.retries, got, tt.want)
+		}
+	}
+
+	if got := NoRetryPolicy.retries(3); got != 0 {
+		t.Errorf("NoRetryPolicy.retries(3) = %d, want 0", got)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	tests := []struct {
+		name        string
+		taskRetries int32
+		retries     []int32
+		want        []time.Duration
+	}{
+		{
+			name:        "default task retries",
+			taskRetries: 0,
+			retries:     []int32{2, 1},
+			want:        []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:        "task retries lower than max retries",
+			taskRetries: 2,
+			retries:     []int32{1},
+			want:        []time.Duration{time.Second},
+		},
+		{
+			name:        "task retries capped by max retries",
+			taskRetries: 10,
+			retries:     []int32{3, 2, 1},
+			want:        []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:        "max backoff",
+			taskRetries: 5,
+			retries:     []int32{0},
+			want:        []time.Duration{5 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, retries := range tt.retries {
+				if got := policy.backoff(tt.taskRetries, retries); got != tt.want[i] {
+					t.Errorf("backoff(%d, %d) = %s, want %s", tt.taskRetries, retries, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyIsFatal(t *testing.T) {
+	if !DefaultRetryPolicy.isFatal(Fatal(errors.New("invalid"))) {
+		t.Error("a `FatalError` must be fatal")
+	}
+	if !DefaultRetryPolicy.isFatal(gorm.ErrRecordNotFound) {
+		t.Error("`gorm.ErrRecordNotFound` must be fatal for the default policy")
+	}
+	if DefaultRetryPolicy.isFatal(errors.New("timeout")) {
+		t.Error("any other error must be retried")
+	}
+}
@@ -0,0 +1,110 @@
+package job
+
+import (
+	"strings"
+	"time"
+
+	"github.com/acme-sky/workers/internal/config"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
+	"github.com/charmbracelet/log"
+)
+
+// Worker settings of a job, after the overrides from the config
+type workerSettings struct {
+	Name           string
+	MaxJobsActive  int
+	Concurrency    int
+	Timeout        time.Duration
+	PollInterval   time.Duration
+	FetchVariables []string
+}
+
+// Returns the worker settings of the job. Every field can be overridden from
+// the environment using the job name as prefix, e.g. for
+// `TM_Search_Flights_On_Airline`:
+//
+//	JOB_TM_SEARCH_FLIGHTS_ON_AIRLINE_MAX_JOBS_ACTIVE=8
+//	JOB_TM_SEARCH_FLIGHTS_ON_AIRLINE_CONCURRENCY=8
+//	JOB_TM_SEARCH_FLIGHTS_ON_AIRLINE_TIMEOUT=2m
+//	JOB_TM_SEARCH_FLIGHTS_ON_AIRLINE_POLL_INTERVAL=1s
+//	JOB_TM_SEARCH_FLIGHTS_ON_AIRLINE_FETCH_VARIABLES=airlines,interests,loopCounter
+func (job *Job) settings() workerSettings {
+	settings := workerSettings{
+		Name:           job.Name,
+		MaxJobsActive:  job.MaxJobsActive,
+		Concurrency:    job.Concurrency,
+		Timeout:        job.Timeout,
+		PollInterval:   job.PollInterval,
+		FetchVariables: job.FetchVariables,
+	}
+
+	conf, err := config.GetConfig()
+	if err != nil {
+		log.Warnf("[%s] Can't read the worker settings: %s", job.Name, err.Error())
+		return settings
+	}
+
+	prefix := "job." + strings.ToLower(strings.ReplaceAll(job.Name, "_", ".")) + "."
+
+	if conf.Exists(prefix + "max.jobs.active") {
+		settings.MaxJobsActive = conf.Int(prefix + "max.jobs.active")
+	}
+	if conf.Exists(prefix + "concurrency") {
+		settings.Concurrency = conf.Int(prefix + "concurrency")
+	}
+	if conf.Exists(prefix + "timeout") {
+		settings.Timeout = conf.Duration(prefix + "timeout")
+	}
+	if conf.Exists(prefix + "poll.interval") {
+		settings.PollInterval = conf.Duration(prefix + "poll.interval")
+	}
+	if conf.Exists(prefix + "fetch.variables") {
+		settings.FetchVariables = nil
+		for _, variable := range strings.Split(conf.String(prefix+"fetch.variables"), ",") {
+			if variable = strings.TrimSpace(variable); len(variable) > 0 {
+				settings.FetchVariables = append(settings.FetchVariables, variable)
+			}
+		}
+	}
+
+	return settings
+}
+
+// Returns the max number of activated jobs, or the Zeebe client default
+func (s workerSettings) maxJobsActive() int {
+	if s.MaxJobsActive > 0 {
+		return s.MaxJobsActive
+	}
+	return worker.DefaultJobWorkerMaxJobActive
+}
+
+// Returns the number of handlers which run at the same time, or the Zeebe
+// client default
+func (s workerSettings) concurrency() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return worker.DefaultJobWorkerConcurrency
+}
+
+// Set the non-zero settings on the worker `builder`. The worker runs a
+// goroutine for every activated job, so none of them waits in its queue,
+// which is dropped when it's closed: `Concurrency` is enforced by the handler
+// instead.
+func (s workerSettings) apply(builder worker.JobWorkerBuilderStep3) worker.JobWorkerBuilderStep3 {
+	builder = builder.MaxJobsActive(s.maxJobsActive()).Concurrency(s.maxJobsActive())
+	if s.Timeout > 0 {
+		builder = builder.Timeout(s.Timeout)
+	}
+	if s.PollInterval > 0 {
+		builder = builder.PollInterval(s.PollInterval)
+	}
+	if len(s.FetchVariables) > 0 {
+		builder = builder.FetchVariables(s.FetchVariables...)
+	}
+
+	log.Debugf("[%s] Worker settings: max_jobs_active=%d concurrency=%d timeout=%s poll_interval=%s fetch_variables=%v",
+		s.Name, s.MaxJobsActive, s.Concurrency, s.Timeout, s.PollInterval, s.FetchVariables)
+
+	return builder
+}
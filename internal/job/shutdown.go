@@ -0,0 +1,47 @@
+package job
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
+	"github.com/charmbracelet/log"
+)
+
+// Number of activated jobs which are running or waiting for a handler slot.
+// It is reported if they are not drained before the shutdown timeout.
+var running atomic.Int64
+
+// Close all the job `workers`, so no new job is activated, and then wait for
+// the activated jobs to send their complete or fail command. It returns an
+// error if they are still running after `timeout`.
+func Shutdown(workers []worker.JobWorker, timeout time.Duration) error {
+	closeWorkers()
+
+	// `Close()` stops polling and blocks until the handlers of the activated
+	// jobs are done, so the workers are closed concurrently.
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w worker.JobWorker) {
+			defer wg.Done()
+			w.Close()
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		return fmt.Errorf("%d activated jobs not completed after %s", running.Load(), timeout)
+	}
+
+	log.Info("All activated jobs are completed")
+	return nil
+}
@@ -0,0 +1,190 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC method used by the workers to poll the jobs
+const activateJobsMethod = "/gateway_protocol.Gateway/ActivateJobs"
+
+// State of the worker of a job type
+type workerState struct {
+	Open bool
+
+	// Time of the last poll which reached the gateway
+	LastPoll time.Time
+
+	// Error of the last poll, nil if it succeeded
+	PollErr error
+}
+
+// State of the workers, by job type
+var workers = struct {
+	sync.Mutex
+	states map[string]*workerState
+}{states: map[string]*workerState{}}
+
+// Returns the state of the worker of the job `name`. It must be called with
+// the lock held.
+func stateOf(name string) *workerState {
+	state, ok := workers.states[name]
+	if !ok {
+		state = &workerState{}
+		workers.states[name] = state
+	}
+
+	return state
+}
+
+// Mark the worker of the job `name` as open or closed
+func setWorkerOpen(name string, open bool) {
+	workers.Lock()
+	defer workers.Unlock()
+
+	stateOf(name).Open = open
+}
+
+// Mark every worker as closed
+func closeWorkers() {
+	workers.Lock()
+	defer workers.Unlock()
+
+	for _, state := range workers.states {
+		state.Open = false
+	}
+}
+
+// Save the result of a poll of the job type `name`. An empty `name` is a poll
+// which failed before the job type was sent, so it's saved for every worker.
+func setPollResult(name string, err error) {
+	workers.Lock()
+	defer workers.Unlock()
+
+	if len(name) == 0 {
+		for _, state := range workers.states {
+			state.PollErr = err
+		}
+		return
+	}
+
+	state := stateOf(name)
+	state.PollErr = err
+	if err == nil {
+		state.LastPoll = time.Now()
+	}
+}
+
+// Returns an error if any of `jobs` has no open worker
+func WorkersOpen(jobs []Job) error {
+	workers.Lock()
+	defer workers.Unlock()
+
+	var closed []string
+	for _, job := range jobs {
+		if state, ok := workers.states[job.Name]; !ok || !state.Open {
+			closed = append(closed, job.Name)
+		}
+	}
+
+	if len(closed) > 0 {
+		return fmt.Errorf("Job workers not open: %s", strings.Join(closed, ", "))
+	}
+
+	return nil
+}
+
+// Returns an error if any of `jobs` has no open worker or its last poll
+// failed
+func WorkersStatus(jobs []Job) error {
+	if err := WorkersOpen(jobs); err != nil {
+		return err
+	}
+
+	workers.Lock()
+	defer workers.Unlock()
+
+	var failing []string
+	for _, job := range jobs {
+		state := workers.states[job.Name]
+		if state.PollErr == nil {
+			continue
+		}
+
+		lastPoll := "never"
+		if !state.LastPoll.IsZero() {
+			lastPoll = state.LastPoll.Format(time.RFC3339)
+		}
+		failing = append(failing, fmt.Sprintf("%s (%s, last poll %s)", job.Name, state.PollErr.Error(), lastPoll))
+	}
+
+	if len(failing) > 0 {
+		return fmt.Errorf("Job workers can't activate jobs: %s", strings.Join(failing, "; "))
+	}
+
+	return nil
+}
+
+// gRPC interceptor which saves the result of the job polls of the workers,
+// read by `WorkersStatus`
+func pollInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if method != activateJobsMethod {
+			return stream, err
+		}
+		if err != nil {
+			setPollResult("", err)
+			return stream, err
+		}
+
+		return &pollStream{ClientStream: stream}, nil
+	}
+}
+
+// Stream of an `ActivateJobs` request, it saves the poll result once the
+// stream ends
+type pollStream struct {
+	grpc.ClientStream
+	jobType string
+}
+
+func (s *pollStream) SendMsg(m interface{}) error {
+	if request, ok := m.(*pb.ActivateJobsRequest); ok {
+		s.jobType = request.GetType()
+	}
+
+	err := s.ClientStream.SendMsg(m)
+	if err != nil && !errors.Is(err, io.EOF) {
+		setPollResult(s.jobType, err)
+	}
+
+	return err
+}
+
+func (s *pollStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	switch {
+	case err == nil:
+		// Some jobs have been activated
+		setPollResult(s.jobType, nil)
+	case errors.Is(err, io.EOF), status.Code(err) == codes.ResourceExhausted:
+		// End of the long polling, or backpressure of a reachable gateway
+		setPollResult(s.jobType, nil)
+	default:
+		setPollResult(s.jobType, err)
+	}
+
+	return err
+}
@@ -0,0 +1,71 @@
+package job
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Client stream which returns `err` on every receive
+type fakeStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s *fakeStream) SendMsg(m interface{}) error { return nil }
+func (s *fakeStream) RecvMsg(m interface{}) error { return s.err }
+
+// Run a poll of `jobType` through `pollInterceptor`, ending with `err`
+func poll(t *testing.T, jobType string, err error) {
+	t.Helper()
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeStream{err: err}, nil
+	}
+
+	stream, _ := pollInterceptor()(context.Background(), &grpc.StreamDesc{}, nil, activateJobsMethod, streamer)
+	if err := stream.SendMsg(&pb.ActivateJobsRequest{Type: jobType}); err != nil {
+		t.Fatal(err)
+	}
+	stream.RecvMsg(&pb.ActivateJobsResponse{})
+}
+
+func TestWorkersStatus(t *testing.T) {
+	jobs := []Job{{Name: "ST_Status_A"}, {Name: "ST_Status_B"}}
+	t.Cleanup(func() {
+		setWorkerOpen("ST_Status_A", false)
+		setWorkerOpen("ST_Status_B", false)
+	})
+
+	if err := WorkersOpen(jobs); err == nil {
+		t.Fatal("expected an error for the workers not open")
+	}
+
+	setWorkerOpen("ST_Status_A", true)
+	setWorkerOpen("ST_Status_B", true)
+	if err := WorkersStatus(jobs); err != nil {
+		t.Fatalf("unexpected error before any poll: %s", err)
+	}
+
+	poll(t, "ST_Status_A", io.EOF)
+	poll(t, "ST_Status_B", status.Error(codes.Unavailable, "connection refused"))
+
+	err := WorkersStatus(jobs)
+	if err == nil || !strings.Contains(err.Error(), "ST_Status_B") || strings.Contains(err.Error(), "ST_Status_A") {
+		t.Fatalf("error = %v, want only `ST_Status_B` failing", err)
+	}
+	if err := WorkersOpen(jobs); err != nil {
+		t.Errorf("a failing poll must not close the worker: %s", err)
+	}
+
+	poll(t, "ST_Status_B", status.Error(codes.ResourceExhausted, "backpressure"))
+	if err := WorkersStatus(jobs); err != nil {
+		t.Errorf("unexpected error after a poll under backpressure: %s", err)
+	}
+}
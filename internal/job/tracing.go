@@ -0,0 +1,61 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+	"github.com/getsentry/sentry-go"
+)
+
+// Start the Sentry transaction of an activated job. The returned context has
+// its own hub tagged with the job info, so the spans of the handler and the
+// errors captured by `captureError` are linked to the transaction.
+func startTransaction(ctx context.Context, job entities.Job) (context.Context, *sentry.Span) {
+	tags := map[string]string{
+		"job_type":             job.GetType(),
+		"job_key":              fmt.Sprint(job.GetKey()),
+		"process_instance_key": fmt.Sprint(job.GetProcessInstanceKey()),
+		"bpmn_process_id":      job.GetBpmnProcessId(),
+		"bpmn_element":         job.GetElementId(),
+	}
+
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+	})
+	ctx = sentry.SetHubOnContext(ctx, hub)
+
+	transaction := sentry.StartTransaction(ctx, job.GetType(), sentry.WithOpName("zeebe.job"), sentry.WithTransactionSource(sentry.SourceTask))
+	for key, value := range tags {
+		transaction.SetTag(key, value)
+	}
+
+	return transaction.Context(), transaction
+}
+
+// Set the status of the job transaction from the result of the handler
+func finishTransaction(transaction *sentry.Span, err error) {
+	var bpmnErr *BPMNError
+	switch {
+	case err == nil:
+		transaction.Status = sentry.SpanStatusOK
+	case errors.As(err, &bpmnErr):
+		transaction.Status = sentry.SpanStatusAborted
+	default:
+		transaction.Status = sentry.SpanStatusInternalError
+	}
+
+	transaction.Finish()
+}
+
+// Capture `err` with the hub of `ctx`, tagged with the job info
+func captureError(ctx context.Context, err error) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+
+	hub.CaptureException(err)
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Wrap a typed `handler` as a `HandlerFunc`. The variables of the activated job
+// are decoded into `In` and validated: fields tagged with `binding:"required"`
+// can't be missing or empty. The returned `Out` is encoded as the variables
+// used to complete the job, so only its fields are set in the process.
+//
+// A missing or wrongly typed variable fails the job without retries.
+func Typed[In any, Out any](handler func(ctx context.Context, job entities.Job, in In) (Out, error)) HandlerFunc {
+	return func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+		var in In
+		if err := DecodeVariables(job.GetVariables(), &in); err != nil {
+			return nil, Fatal(err)
+		}
+
+		out, err := handler(ctx, job, in)
+		if err != nil {
+			return nil, err
+		}
+
+		return EncodeVariables(out)
+	}
+}
+
+// Decode the JSON `variables` into `in`, which must be a pointer to a struct,
+// and check its required fields.
+func DecodeVariables(variables string, in interface{}) error {
+	if err := json.Unmarshal([]byte(variables), in); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("Variable `%s` must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return fmt.Errorf("Invalid variables: %s", err.Error())
+	}
+
+	value := reflect.ValueOf(in).Elem()
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var missing []string
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("binding") != "required" {
+			continue
+		}
+
+		if value.Field(i).IsZero() {
+			missing = append(missing, VariableName(field))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing required variables: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Encode `out` as a variables map
+func EncodeVariables(out interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := map[string]interface{}{}
+	if string(data) == "null" {
+		return variables, nil
+	}
+
+	if err := json.Unmarshal(data, &variables); err != nil {
+		return nil, fmt.Errorf("Output must be an object: %s", err.Error())
+	}
+
+	return variables, nil
+}
+
+// Returns the variable name of a struct field from its JSON tag
+func VariableName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if len(name) == 0 || name == "-" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package job
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/pb"
+)
+
+type typedInput struct {
+	OfferId  uint     `json:"offer_id" binding:"required"`
+	Token    string   `json:"token" binding:"required"`
+	Journeys []uint   `json:"journeys" binding:"required"`
+	Note     string   `json:"note"`
+	Price    *float64 `json:"price"`
+}
+
+func TestDecodeVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables string
+		wantErr   string
+	}{
+		{
+			name:      "all required variables",
+			variables: `{"offer_id": 1, "token": "abc", "journeys": [1, 2]}`,
+		},
+		{
+			name:      "unknown variables are ignored",
+			variables: `{"offer_id": 1, "token": "abc", "journeys": [1], "loopCounter": 2}`,
+		},
+		{
+			name:      "missing required variables",
+			variables: `{"note": "hi"}`,
+			wantErr:   "Missing required variables: offer_id, token, journeys",
+		},
+		{
+			name:      "empty required variables",
+			variables: `{"offer_id": 0, "token": "", "journeys": null}`,
+			wantErr:   "Missing required variables: offer_id, token, journeys",
+		},
+		{
+			name:      "wrong type",
+			variables: `{"offer_id": "one", "token": "abc", "journeys": [1]}`,
+			wantErr:   "Variable `offer_id` must be uint, got string",
+		},
+		{
+			name:      "invalid JSON",
+			variables: `{`,
+			wantErr:   "Invalid variables",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in typedInput
+			err := DecodeVariables(tt.variables, &in)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTypedFailsWithoutRetries(t *testing.T) {
+	called := false
+	handler := Typed(func(ctx context.Context, job entities.Job, in typedInput) (map[string]interface{}, error) {
+		called = true
+		return nil, nil
+	})
+
+	job := entities.Job{ActivatedJob: &pb.ActivatedJob{Variables: `{"token": "abc"}`}}
+	if _, err := handler(context.Background(), job); !IsFatal(err) {
+		t.Fatalf("expected a fatal error, got %v", err)
+	}
+	if called {
+		t.Error("the handler must not be called with invalid variables")
+	}
+}
+
+func TestTypedEncodesOutput(t *testing.T) {
+	type output struct {
+		Distance int32  `json:"distance"`
+		Status   string `json:"rent_status,omitempty"`
+	}
+
+	handler := Typed(func(ctx context.Context, job entities.Job, in typedInput) (*output, error) {
+		return &output{Distance: int32(in.OfferId) * 10}, nil
+	})
+
+	job := entities.Job{ActivatedJob: &pb.ActivatedJob{Variables: `{"offer_id": 4, "token": "abc", "journeys": [1]}`}}
+	variables, err := handler(context.Background(), job)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(variables) != 1 || variables["distance"] != float64(40) {
+		t.Errorf("variables = %v, want only distance = 40", variables)
+	}
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+	"github.com/charmbracelet/log"
+)
+
+// Configure the default logger. `format` is `text` (default), `json` or
+// `logfmt`; `level` is `debug`, `info` (default), `warn` or `error`.
+// Every line is redacted before it is written to stderr.
+func Setup(format string, level string) error {
+	logger := log.NewWithOptions(&redactWriter{w: os.Stderr}, log.Options{ReportTimestamp: true})
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		logger.SetFormatter(log.TextFormatter)
+	case "json":
+		logger.SetFormatter(log.JSONFormatter)
+		logger.SetTimeFormat(time.RFC3339)
+	case "logfmt":
+		logger.SetFormatter(log.LogfmtFormatter)
+		logger.SetTimeFormat(time.RFC3339)
+	default:
+		return fmt.Errorf("Invalid `LOG_FORMAT` `%s`", format)
+	}
+
+	if len(level) > 0 {
+		parsed, err := log.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("Invalid `LOG_LEVEL` `%s`", level)
+		}
+		logger.SetLevel(parsed)
+	}
+
+	log.SetDefault(logger)
+	return nil
+}
+
+// Returns the logger of `ctx` or the default one
+func FromContext(ctx context.Context) *log.Logger {
+	return log.FromContext(ctx)
+}
+
+// Returns a copy of `ctx` whose logger adds `keyvals` to every line
+func With(ctx context.Context, keyvals ...interface{}) context.Context {
+	return log.WithContext(ctx, FromContext(ctx).With(keyvals...))
+}
+
+// Returns a copy of `ctx` whose logger adds the fields of `job`: its type, its
+// key, the process instance key and, when they are in the variables, the
+// offer id and the user id.
+func WithJob(ctx context.Context, job entities.Job) context.Context {
+	keyvals := []interface{}{
+		"job_type", job.GetType(),
+		"job_key", job.GetKey(),
+		"process_instance_key", job.GetProcessInstanceKey(),
+	}
+
+	if variables, err := job.GetVariablesAsMap(); err == nil {
+		if id := lookup(variables, "offer_id", "offer.id"); id != nil {
+			keyvals = append(keyvals, "offer_id", id)
+		}
+		if id := lookup(variables, "user_id", "offer.user.ID", "journey.user.ID", "user.ID"); id != nil {
+			keyvals = append(keyvals, "user_id", id)
+		}
+	}
+
+	return With(ctx, keyvals...)
+}
+
+// Returns the first of the dotted `paths` set in `variables`, formatted
+// without decimals if it's a number
+func lookup(variables map[string]interface{}, paths ...string) interface{} {
+	for _, path := range paths {
+		var value interface{} = variables
+		for _, field := range strings.Split(path, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = object[field]
+		}
+
+		switch v := value.(type) {
+		case nil:
+			continue
+		case float64:
+			if v == 0 {
+				continue
+			}
+			return int64(v)
+		default:
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Writer which redacts every line before writing it to `w`. The logger
+// writes a line per call.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
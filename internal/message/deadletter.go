@@ -0,0 +1,331 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Headers set on the dead-lettered and retried messages
+const (
+	headerAttempts = "x-attempts"
+	headerReason   = "x-failure-reason"
+	headerError    = "x-failure-error"
+	headerFailedAt = "x-failed-at"
+
+	// Validation report of a payload which doesn't match its schema
+	headerProblems = "x-validation-problems"
+)
+
+// Reasons set on dead-lettered messages
+const (
+	ReasonInvalidBody    = "invalid_body"
+	ReasonInvalidMessage = "invalid_message"
+	ReasonUnknownMessage = "unknown_message"
+	ReasonInvalidPayload = "invalid_payload"
+	ReasonRejected       = "rejected"
+	ReasonPublishFailed  = "publish_failed"
+)
+
+// Backoff before retrying a message, multiplied by its attempts
+var retryBackoff = time.Second
+
+// Dead-lettered message
+type DeadLetter struct {
+	Body     []byte
+	Reason   string
+	Error    string
+	Attempts int
+	FailedAt string
+
+	// Only for `ReasonInvalidPayload`
+	Problems []string
+}
+
+// Publish `d` to the dead-letter exchange with the failure `reason` and `err`
+// in its headers, then acknowledge it once the broker confirms the dead
+// letter. If the publish fails or it's not confirmed, the delivery is
+// requeued. `ch` must be in confirm mode.
+func deadLetter(ctx context.Context, ch *amqp.Channel, t Topology, d amqp.Delivery, reason string, err error) {
+	headers := copyHeaders(d.Headers)
+	headers[headerReason] = reason
+	headers[headerError] = err.Error()
+	headers[headerAttempts] = int32(attempts(d))
+	headers[headerFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		problems := make([]interface{}, 0, len(validation.Problems))
+		for _, problem := range validation.Problems {
+			problems = append(problems, problem)
+		}
+		headers[headerProblems] = problems
+	}
+
+	publishing := amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		ReplyTo:       d.ReplyTo,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Body:          d.Body,
+	}
+
+	if err := publishConfirmed(ctx, ch, t.DeadLetterExchange(), "", publishing); err != nil {
+		log.Error("[RabbitMQ] Failed to dead-letter a message", "err", err)
+		d.Nack(false, true)
+		return
+	}
+
+	log.Warn("[RabbitMQ] Message dead-lettered", "reason", reason, "err", err)
+	d.Ack(false)
+}
+
+// Publish `d` again to the consumed queue with one more attempt, after a
+// backoff, and acknowledge it once the broker confirms the new message. It
+// returns false if the message has reached `maxAttempts`, so it must be
+// dead-lettered instead. `ch` must be in confirm mode.
+func retry(ctx context.Context, ch *amqp.Channel, t Topology, d amqp.Delivery, maxAttempts int) bool {
+	attempt := attempts(d)
+	if attempt >= maxAttempts {
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		// The consumer is stopping: the message is delivered again with the
+		// same attempts
+		d.Nack(false, true)
+		return true
+	case <-time.After(time.Duration(attempt) * retryBackoff):
+	}
+
+	headers := copyHeaders(d.Headers)
+	headers[headerAttempts] = int32(attempt + 1)
+
+	publishing := amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		ReplyTo:       d.ReplyTo,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Body:          d.Body,
+	}
+
+	if err := publishConfirmed(ctx, ch, "", t.Queue, publishing); err != nil {
+		log.Error("[RabbitMQ] Failed to retry a message", "err", err)
+		d.Nack(false, true)
+		return true
+	}
+
+	log.Warn("[RabbitMQ] Message retried", "attempt", attempt+1, "max_attempts", maxAttempts)
+	d.Ack(false)
+	return true
+}
+
+// Publish `publishing` on `ch`, which must be in confirm mode, and wait until
+// the broker confirms it
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, exchange string, key string, publishing amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Not confirmed: %w", err)
+	}
+	if !acked {
+		return errors.New("Rejected by the broker")
+	}
+
+	return nil
+}
+
+// Returns true if a Zeebe error is transient, so the message can be retried
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// Returns the delivery attempts of `d`, starting from 1
+func attempts(d amqp.Delivery) int {
+	switch v := d.Headers[headerAttempts].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+
+	return 1
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	result := amqp.Table{}
+	for key, value := range headers {
+		result[key] = value
+	}
+
+	return result
+}
+
+// Returns up to `limit` messages of the dead-letter queue of `t` at `uri`,
+// without removing them
+func InspectDeadLetters(uri string, t Topology, limit int) ([]DeadLetter, error) {
+	conn, ch, err := open(uri, t)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Closing the channel requeues the messages which are not acknowledged
+	defer ch.Close()
+
+	var result []DeadLetter
+	for limit <= 0 || len(result) < limit {
+		d, ok, err := ch.Get(t.DeadLetterQueue(), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		result = append(result, deadLetterFrom(d))
+	}
+
+	return result, nil
+}
+
+// Move up to `limit` messages from the dead-letter queue of `t` at `uri` back
+// to the consumed queue as persistent messages, with their attempts reset.
+// Every message is removed only once the broker confirms its copy. It returns the number of
+// replayed messages. The workers must have declared the queue, otherwise
+// nothing is replayed.
+func ReplayDeadLetters(uri string, t Topology, limit int) (int, error) {
+	conn, ch, err := open(uri, t)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclarePassive(t.Queue, true, false, false, false, nil); err != nil {
+		return 0, fmt.Errorf("Queue `%s` does not exist, have the workers been started? %w", t.Queue, err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		return 0, fmt.Errorf("Failed to enable publisher confirms: %w", err)
+	}
+
+	// Messages dead-lettered again while replaying are left in the queue
+	queue, err := ch.QueueDeclarePassive(t.DeadLetterQueue(), true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > queue.Messages {
+		limit = queue.Messages
+	}
+
+	replayed := 0
+	for replayed < limit {
+		d, ok, err := ch.Get(t.DeadLetterQueue(), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := copyHeaders(d.Headers)
+		delete(headers, headerAttempts)
+		delete(headers, headerReason)
+		delete(headers, headerError)
+		delete(headers, headerFailedAt)
+		delete(headers, headerProblems)
+
+		publishing := amqp.Publishing{
+			Headers:       headers,
+			ContentType:   d.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     d.MessageId,
+			ReplyTo:       d.ReplyTo,
+			CorrelationId: d.CorrelationId,
+			Timestamp:     d.Timestamp,
+			Body:          d.Body,
+		}
+
+		if err := publishConfirmed(context.Background(), ch, "", t.Queue, publishing); err != nil {
+			d.Nack(false, true)
+			return replayed, err
+		}
+
+		if err := d.Ack(false); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// Connect to `uri` and declare the dead-letter queue of `t`
+func open(uri string, t Topology) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("Failed to open a channel: %w", err)
+	}
+
+	if err := t.declareDeadLetter(ch); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, ch, nil
+}
+
+func deadLetterFrom(d amqp.Delivery) DeadLetter {
+	letter := DeadLetter{Body: d.Body, Attempts: attempts(d)}
+	letter.Reason, _ = d.Headers[headerReason].(string)
+	letter.Error, _ = d.Headers[headerError].(string)
+	letter.FailedAt, _ = d.Headers[headerFailedAt].(string)
+
+	problems, _ := d.Headers[headerProblems].([]interface{})
+	for _, problem := range problems {
+		if problem, ok := problem.(string); ok {
+			letter.Problems = append(letter.Problems, problem)
+		}
+	}
+
+	// Messages rejected by the consumer are dead-lettered by RabbitMQ, which
+	// only sets `x-first-death-reason`
+	if len(letter.Reason) == 0 {
+		letter.Reason, _ = d.Headers["x-first-death-reason"].(string)
+	}
+
+	return letter
+}
@@ -0,0 +1,78 @@
+package message
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Domain events published by the handlers
+const (
+	EventOfferCreated     = "offer.created"
+	EventOfferRedeemed    = "offer.redeemed"
+	EventJourneyBooked    = "journey.booked"
+	EventPaymentCompleted = "payment.completed"
+	EventRentBooked       = "rent.booked"
+	EventInvoiceCreated   = "invoice.created"
+)
+
+// Body of a published event
+type Event struct {
+	// Id derived from the key of the event and its name, also set as the
+	// broker message id
+	Id string `json:"id"`
+
+	// Event name, also used to route it, e.g. `offer.created`
+	Name string `json:"name"`
+
+	OccurredAt time.Time `json:"occurred_at"`
+
+	// Event payload
+	Data interface{} `json:"data"`
+}
+
+// Returns a new event `name` with `data`, occurred now. `key` identifies what
+// caused the event, e.g. `JobKey(job)`: the same key and name always give the
+// same id, so an event emitted again by a retried job is deduplicated by the
+// transport.
+func NewEvent(key string, name string, data interface{}) Event {
+	return Event{Id: eventId(key, name), Name: name, OccurredAt: time.Now().UTC(), Data: data}
+}
+
+// Returns the key of the events emitted by `job`
+func JobKey(job entities.Job) string {
+	return fmt.Sprintf("job/%d", job.GetKey())
+}
+
+// Publish the event `name` with `data` and the `key` of `NewEvent` with the
+// global transport. The state of the handlers is already saved when an event
+// is emitted, so a failure is only logged and the job goes on. Nothing is
+// published if the transport is not initialized, e.g. in tests.
+func Emit(ctx context.Context, key string, name string, data interface{}) {
+	logger := logging.FromContext(ctx)
+
+	if transport == nil {
+		logger.Debug("Event not published, no transport", "event", name)
+		return
+	}
+
+	if err := transport.Publish(ctx, NewEvent(key, name, data)); err != nil {
+		logger.Error("Failed to publish an event", "event", name, "err", err)
+		metrics.EventsFailed.WithLabelValues(name).Inc()
+		return
+	}
+
+	logger.Info("Event published", "event", name)
+	metrics.EventsPublished.WithLabelValues(name).Inc()
+}
+
+func eventId(key string, name string) string {
+	sum := sha256.Sum256([]byte(key + "/" + name))
+	return hex.EncodeToString(sum[:16])
+}
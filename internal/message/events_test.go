@@ -0,0 +1,19 @@
+package message
+
+import "testing"
+
+func TestEventIdIsDeterministic(t *testing.T) {
+	first := NewEvent("job/42", EventOfferCreated, nil)
+	again := NewEvent("job/42", EventOfferCreated, map[string]interface{}{"offer_id": 1})
+
+	if first.Id != again.Id {
+		t.Errorf("ids = %s, %s, want the same id for the same key and name", first.Id, again.Id)
+	}
+
+	if other := NewEvent("job/43", EventOfferCreated, nil); other.Id == first.Id {
+		t.Error("events of different jobs must have different ids")
+	}
+	if other := NewEvent("job/42", EventOfferRedeemed, nil); other.Id == first.Id {
+		t.Error("different events of the same job must have different ids")
+	}
+}
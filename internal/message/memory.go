@@ -0,0 +1,258 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// In-memory transport, for tests and local runs without a broker. Messages
+// sent with `Send` are consumed in order, transient failures are delivered
+// again right away and the published events and the dead-lettered messages
+// are recorded, as well as the replies. It's safe for concurrent use.
+type Memory struct {
+	maxAttempts int
+	state       consumerState
+
+	mu          sync.Mutex
+	queue       []Delivery
+	events      []Event
+	replies     []Reply
+	deadLetters []DeadLetter
+	// Messages sent and not acknowledged or dead-lettered yet
+	pending int
+	// Signaled when a message is sent
+	sent chan struct{}
+	// Closed and replaced when there are no pending messages
+	idle chan struct{}
+}
+
+var _ Transport = (*Memory)(nil)
+
+// Returns an in-memory transport which dead-letters the messages after
+// `maxAttempts` deliveries
+func NewMemory(maxAttempts int) *Memory {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	idle := make(chan struct{})
+	close(idle)
+
+	return &Memory{
+		maxAttempts: maxAttempts,
+		state:       consumerState{name: "Memory", state: StateStopped},
+		sent:        make(chan struct{}, 1),
+		idle:        idle,
+	}
+}
+
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+func (m *Memory) Status() error {
+	return m.state.status()
+}
+
+func (m *Memory) Running() error {
+	return m.state.running()
+}
+
+// Send a message with `body` to the consumer
+func (m *Memory) Send(body []byte) {
+	m.Request(body, "", "")
+}
+
+// Send a request with `body` to the consumer, whose reply is recorded with
+// `replyTo` and `correlationId`
+func (m *Memory) Request(body []byte, replyTo string, correlationId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending == 0 {
+		m.idle = make(chan struct{})
+	}
+	m.pending++
+
+	m.queue = append(m.queue, Delivery{
+		Body:          body,
+		Attempt:       1,
+		MaxAttempts:   m.maxAttempts,
+		ReplyTo:       replyTo,
+		CorrelationId: correlationId,
+	})
+	m.notify()
+}
+
+// Send `body` encoded as JSON to the consumer
+func (m *Memory) SendMessage(body MessageBody) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	m.Send(data)
+	return nil
+}
+
+// Wait until every sent message is acknowledged or dead-lettered, or until
+// `ctx` is done
+func (m *Memory) Wait(ctx context.Context) error {
+	m.mu.Lock()
+	idle := m.idle
+	m.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns a copy of the published events, in the order they were published
+func (m *Memory) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Event{}, m.events...)
+}
+
+// Returns a copy of the sent replies, in the order they were sent
+func (m *Memory) Replies() []Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Reply{}, m.replies...)
+}
+
+// Returns a copy of the dead-lettered messages
+func (m *Memory) DeadLetters() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]DeadLetter{}, m.deadLetters...)
+}
+
+// Consume the sent messages until `ctx` is done
+func (m *Memory) Consume(ctx context.Context, handler Handler) {
+	m.state.set(StateConnected, nil)
+	defer m.state.set(StateStopped, nil)
+
+	for {
+		d, ok := m.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.sent:
+			}
+			continue
+		}
+
+		m.handle(ctx, d, handler)
+	}
+}
+
+func (m *Memory) handle(ctx context.Context, d Delivery, handler Handler) {
+	err := handler(ctx, d)
+	if err == nil {
+		m.done(nil)
+		return
+	}
+
+	var rejected *RejectError
+	if errors.As(err, &rejected) {
+		m.done(deadLetterOf(d, rejected.Reason, rejected.Err))
+		return
+	}
+
+	if d.Attempt >= d.MaxAttempts {
+		m.done(deadLetterOf(d, ReasonPublishFailed, err))
+		return
+	}
+
+	d.Attempt++
+	m.mu.Lock()
+	m.queue = append(m.queue, d)
+	m.mu.Unlock()
+}
+
+// Record the published `event`
+func (m *Memory) Publish(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Record the reply `body` to `to`
+func (m *Memory) Reply(ctx context.Context, to string, correlationId string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.replies = append(m.replies, Reply{To: to, CorrelationId: correlationId, Body: body})
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Returns the next message of the queue, if any
+func (m *Memory) next() (Delivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.queue) == 0 {
+		return Delivery{}, false
+	}
+
+	d := m.queue[0]
+	m.queue = m.queue[1:]
+	return d, true
+}
+
+// Mark a message as handled, recording `letter` if it's dead-lettered
+func (m *Memory) done(letter *DeadLetter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if letter != nil {
+		m.deadLetters = append(m.deadLetters, *letter)
+	}
+
+	m.pending--
+	if m.pending == 0 {
+		close(m.idle)
+	}
+}
+
+// Wake up the consumer, if it's waiting for a message
+func (m *Memory) notify() {
+	select {
+	case m.sent <- struct{}{}:
+	default:
+	}
+}
+
+func deadLetterOf(d Delivery, reason string, err error) *DeadLetter {
+	letter := &DeadLetter{
+		Body:     d.Body,
+		Reason:   reason,
+		Error:    err.Error(),
+		Attempts: d.Attempt,
+		FailedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		letter.Problems = validation.Problems
+	}
+
+	return letter
+}
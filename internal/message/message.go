@@ -3,11 +3,13 @@ package message
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
-	"github.com/acme-sky/workers/internal/config"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
 	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
 	"github.com/charmbracelet/log"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // Body received in message to perform a new job
@@ -18,77 +20,106 @@ type MessageBody struct {
 	// Corellation key value
 	CorrelationKey string `json:"correlation_key"`
 
+	// Name of the payload field used as correlation key. If set, it has
+	// precedence over `CorrelationKey`
+	CorrelationVariable string `json:"correlation_variable"`
+
 	// Json payload value
 	Payload map[string]interface{} `json:"payload"`
 }
 
-// Instance a RabbitMQ message broker for messaging management
-func MessageBroker(client *zbc.Client) {
-	ctx := context.Background()
-
-	conf, err := config.GetConfig()
-
-	if err != nil {
-		log.Warnf("[RabbitMQ] Error loading the config: %s", err.Error())
+// Consume the inbound messages of the global transport until `ctx` is done,
+// publishing them to Zeebe with `client`. The transport reconnects on its own,
+// e.g. after a broker restart.
+func MessageBroker(ctx context.Context, client *zbc.Client) {
+	if transport == nil {
+		log.Warn("[Message] No transport, call `InitTransport()` first")
 		return
 	}
 
-	conn, err := amqp.Dial(conf.String("rabbitmq.uri"))
+	transport.Consume(ctx, func(ctx context.Context, d Delivery) error {
+		return Process(ctx, client, d)
+	})
+}
 
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %s", err.Error())
-	} else {
-		log.Info("Connected to RabbitMQ")
+// Publish the Zeebe message requested by the delivery `d` with `client`.
+// Invalid messages, messages which are not registered or whose payload
+// doesn't match their schema and messages rejected by Zeebe are rejected,
+// while transient Zeebe failures are retried up to `d.MaxAttempts`
+// deliveries. The reply address of a request is added to the variables, so
+// the step which completes it can reply with `Replier`. A payment confirmed by
+// the bank marks its offer as paid.
+func Process(ctx context.Context, client *zbc.Client, d Delivery) error {
+	var body MessageBody
+	if err := json.Unmarshal(d.Body, &body); err != nil {
+		log.Error("[Message] Invalid message body", "err", err)
+		metrics.MessagesConsumed.WithLabelValues("unknown").Inc()
+		metrics.MessagesRejected.WithLabelValues("unknown").Inc()
+		return Reject(ReasonInvalidBody, err)
 	}
 
-	defer conn.Close()
-
-	ch, err := conn.Channel()
-
-	if err != nil {
-		log.Fatalf("Failed to open a channel: %s", err.Error())
+	if !Registered(body.Name) {
+		log.Error("[Message] Message not allowed", "message", body.Name)
+		metrics.MessagesConsumed.WithLabelValues("unknown").Inc()
+		metrics.MessagesRejected.WithLabelValues("unknown").Inc()
+		return Reject(ReasonUnknownMessage, fmt.Errorf("%w: `%s`", ErrUnknownMessage, body.Name))
 	}
 
-	defer ch.Close()
+	metrics.MessagesConsumed.WithLabelValues(body.Name).Inc()
 
-	q, err := ch.QueueDeclare("acme_messages", false, true, false, false, nil)
+	if err := Validate(body.Name, body.Payload); err != nil {
+		log.Error("[Message] Invalid payload", "message", body.Name, "err", err)
+		metrics.MessagesRejected.WithLabelValues(body.Name).Inc()
+		return Reject(ReasonInvalidPayload, err)
+	}
 
+	correlationKey, err := acmejob.ResolveCorrelationKey(body.CorrelationKey, body.CorrelationVariable, body.Payload)
 	if err != nil {
-		log.Fatalf("Failed to declare a queue: %s", err.Error())
+		log.Error("[Message] Invalid message", "message", body.Name, "err", err)
+		metrics.MessagesRejected.WithLabelValues(body.Name).Inc()
+		return Reject(ReasonInvalidMessage, err)
 	}
 
-	if err := ch.Qos(1, 0, false); err != nil {
-		log.Fatalf("Failed to set QoS: %s", err.Error())
+	variables := body.Payload
+	if d.ReplyTo != "" {
+		// Copied so the logged payload is the one received
+		variables = make(map[string]interface{}, len(body.Payload)+1)
+		for name, value := range body.Payload {
+			variables[name] = value
+		}
+		variables[replyVariable] = ReplyAddress{To: d.ReplyTo, CorrelationId: d.CorrelationId}
 	}
 
-	msgs, err := ch.Consume(q.Name, "", false, false, false, false, nil)
-
+	res, err := (*client).NewPublishMessageCommand().MessageName(body.Name).CorrelationKey(correlationKey).VariablesFromMap(variables)
 	if err != nil {
-		log.Fatalf("Failed to register a consumer: %s", err.Error())
+		log.Error("[Message] Invalid message", "message", body.Name, "err", err)
+		metrics.MessagesRejected.WithLabelValues(body.Name).Inc()
+		return Reject(ReasonInvalidMessage, err)
 	}
 
-	var forever chan struct{}
+	// The message is already consumed, so it is sent even if `ctx` is done in
+	// the meantime.
+	if _, err := res.Send(context.Background()); err != nil {
+		log.Error("[Message] Failed to send message", "message", body.Name, "err", err)
 
-	for d := range msgs {
-		var body MessageBody
-		if err := json.Unmarshal(d.Body, &body); err != nil {
-			log.Errorf("Error on a received message: %s %s %v", err.Error(), d.Body, body)
-			continue
+		if !isTransient(err) {
+			metrics.MessagesRejected.WithLabelValues(body.Name).Inc()
+			return Reject(ReasonRejected, err)
 		}
 
-		res, err := (*client).NewPublishMessageCommand().MessageName(body.Name).CorrelationKey(body.CorrelationKey).VariablesFromMap(body.Payload)
-
-		if err != nil {
-			log.Error(err.Error())
-		} else {
-			if _, err := res.Send(ctx); err != nil {
-				log.Error(err.Error())
-			} else {
-				log.Infof("[RabbitMQ] Sent message to `%s` with correlation key = `%s` with payload = `%v`\n", body.Name, body.CorrelationKey, body.Payload)
-			}
+		if d.Attempt >= d.MaxAttempts {
+			metrics.MessagesRejected.WithLabelValues(body.Name).Inc()
+			return Reject(ReasonPublishFailed, err)
 		}
-		d.Ack(false)
+
+		return err
 	}
 
-	<-forever
+	log.Info("[Message] Sent message", "message", body.Name, "correlation_key", correlationKey, "payload", logging.RedactVariables(body.Payload))
+	metrics.MessagesPublished.WithLabelValues(body.Name, metrics.SourceInbound).Inc()
+
+	if isPaymentConfirmed(body) {
+		paymentCompleted(ctx, body.Payload)
+	}
+	return nil
 }
@@ -0,0 +1,401 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/knadh/koanf/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Headers of a request sent on NATS, since the reply subject of a message
+// stored by JetStream is not kept
+const (
+	headerReplyTo       = "Reply-To"
+	headerCorrelationId = "Correlation-Id"
+)
+
+// Settings of the NATS transport
+type NATSConfig struct {
+	URL string
+
+	// JetStream stream storing the inbound messages published on `Subject`
+	Stream  string
+	Subject string
+
+	// Durable consumer of `Stream`, shared by every worker
+	Consumer string
+
+	// JetStream stream storing the events, published on
+	// `<EventsSubject>.<event name>`
+	EventsStream  string
+	EventsSubject string
+}
+
+// Returns the NATS settings from `conf`, e.g. `NATS_URL=nats://nats:4222`,
+// `NATS_STREAM=ACME_MESSAGES`, `NATS_SUBJECT=acme.messages`,
+// `NATS_CONSUMER=workers`, `NATS_EVENTS_STREAM=ACME_EVENTS` and
+// `NATS_EVENTS_SUBJECT=acme.events`
+func NATSConfigFromConfig(conf *koanf.Koanf) NATSConfig {
+	config := NATSConfig{
+		URL:           conf.String("nats.url"),
+		Stream:        conf.String("nats.stream"),
+		Subject:       conf.String("nats.subject"),
+		Consumer:      conf.String("nats.consumer"),
+		EventsStream:  conf.String("nats.events.stream"),
+		EventsSubject: conf.String("nats.events.subject"),
+	}
+
+	defaults := []struct {
+		value    *string
+		fallback string
+	}{
+		{&config.URL, nats.DefaultURL},
+		{&config.Stream, "ACME_MESSAGES"},
+		{&config.Subject, "acme.messages"},
+		{&config.Consumer, "workers"},
+		{&config.EventsStream, "ACME_EVENTS"},
+		{&config.EventsSubject, "acme.events"},
+	}
+	for _, d := range defaults {
+		if len(*d.value) == 0 {
+			*d.value = d.fallback
+		}
+	}
+
+	return config
+}
+
+// Stream storing the dead-lettered messages
+func (c NATSConfig) DeadLetterStream() string {
+	return c.Stream + "_DEAD"
+}
+
+// Subject of the dead-lettered messages
+func (c NATSConfig) DeadLetterSubject() string {
+	return c.Subject + ".dead"
+}
+
+// NATS JetStream transport. Messages are stored in a file stream and consumed
+// one at a time by a durable consumer, so they are not lost while the workers
+// are down. Events are acknowledged by JetStream once stored, and
+// deduplicated by their id.
+type NATS struct {
+	config      NATSConfig
+	maxAttempts int
+	state       consumerState
+
+	mu             sync.Mutex
+	conn           *nats.Conn
+	js             jetstream.JetStream
+	eventsDeclared bool
+}
+
+var _ Transport = (*NATS)(nil)
+
+// Returns a NATS transport with `config`
+func NewNATS(config NATSConfig, maxAttempts int) *NATS {
+	return &NATS{
+		config:      config,
+		maxAttempts: maxAttempts,
+		state:       consumerState{name: "NATS", state: StateStopped},
+	}
+}
+
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+func (n *NATS) Status() error {
+	return n.state.status()
+}
+
+func (n *NATS) Running() error {
+	return n.state.running()
+}
+
+// Consume the messages of the stream until `ctx` is done. The NATS client
+// reconnects on its own; if the streams or the consumer can't be declared, it
+// tries again with an exponential backoff.
+func (n *NATS) Consume(ctx context.Context, handler Handler) {
+	reconnect(ctx, &n.state, func(ctx context.Context) (bool, error) {
+		return n.consume(ctx, handler)
+	})
+}
+
+// Declare the streams and the consumer and consume the messages until `ctx` is
+// done or the consumer fails. It returns true if the consumer has been
+// registered, and the error which stopped it.
+func (n *NATS) consume(ctx context.Context, handler Handler) (bool, error) {
+	_, js, err := n.connect()
+	if err != nil {
+		return false, err
+	}
+
+	streams := []jetstream.StreamConfig{
+		{Name: n.config.Stream, Subjects: []string{n.config.Subject}, Storage: jetstream.FileStorage},
+		{Name: n.config.DeadLetterStream(), Subjects: []string{n.config.DeadLetterSubject()}, Storage: jetstream.FileStorage},
+	}
+	for _, stream := range streams {
+		if _, err := js.CreateOrUpdateStream(ctx, stream); err != nil {
+			return false, fmt.Errorf("Failed to declare the stream `%s`: %w", stream.Name, err)
+		}
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, n.config.Stream, jetstream.ConsumerConfig{
+		Durable:       n.config.Consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: n.config.Subject,
+		MaxAckPending: 1,
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to declare the consumer `%s`: %w", n.config.Consumer, err)
+	}
+
+	msgs, err := consumer.Messages(jetstream.PullMaxMessages(1))
+	if err != nil {
+		return false, fmt.Errorf("Failed to register a consumer: %w", err)
+	}
+	defer msgs.Stop()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			msgs.Stop()
+		case <-stop:
+		}
+	}()
+
+	log.Info("[NATS] Connected, consuming messages")
+	n.state.set(StateConnected, nil)
+
+	for {
+		msg, err := msgs.Next()
+		if ctx.Err() != nil {
+			return true, nil
+		}
+		if err != nil {
+			return true, fmt.Errorf("Consumer stopped: %w", err)
+		}
+
+		n.handle(ctx, js, msg, handler)
+	}
+}
+
+// Handle `msg` and acknowledge it. Rejected messages are dead-lettered, while
+// the ones which fail with a transient error are delivered again after a
+// backoff, up to `maxAttempts` deliveries.
+func (n *NATS) handle(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, handler Handler) {
+	attempt := 1
+	if metadata, err := msg.Metadata(); err == nil {
+		attempt = int(metadata.NumDelivered)
+	}
+
+	d := Delivery{
+		Body:          msg.Data(),
+		Attempt:       attempt,
+		MaxAttempts:   n.maxAttempts,
+		ReplyTo:       msg.Headers().Get(headerReplyTo),
+		CorrelationId: msg.Headers().Get(headerCorrelationId),
+	}
+	err := handler(ctx, d)
+	if err == nil {
+		msg.Ack()
+		return
+	}
+
+	var rejected *RejectError
+	if errors.As(err, &rejected) {
+		n.deadLetter(js, msg, d, rejected.Reason, rejected.Err)
+		return
+	}
+
+	if attempt >= n.maxAttempts {
+		n.deadLetter(js, msg, d, ReasonPublishFailed, err)
+		return
+	}
+
+	log.Warn("[NATS] Message retried", "attempt", attempt+1, "max_attempts", n.maxAttempts)
+	msg.NakWithDelay(time.Duration(attempt) * retryBackoff)
+}
+
+// Publish `msg` to the dead-letter subject with the failure `reason` and
+// `err` in its headers, then acknowledge it. If the publish fails, the message
+// is delivered again.
+func (n *NATS) deadLetter(js jetstream.JetStream, msg jetstream.Msg, d Delivery, reason string, err error) {
+	letter := deadLetterOf(d, reason, err)
+
+	header := nats.Header{}
+	for key, values := range msg.Headers() {
+		header[key] = values
+	}
+	header.Set(headerReason, letter.Reason)
+	header.Set(headerError, letter.Error)
+	header.Set(headerAttempts, strconv.Itoa(letter.Attempts))
+	header.Set(headerFailedAt, letter.FailedAt)
+	for _, problem := range letter.Problems {
+		header.Add(headerProblems, problem)
+	}
+
+	// The message is already consumed, so it is dead-lettered even if the
+	// consumer is stopping
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if _, err := js.PublishMsg(ctx, &nats.Msg{Subject: n.config.DeadLetterSubject(), Header: header, Data: d.Body}); err != nil {
+		log.Error("[NATS] Failed to dead-letter a message", "err", err)
+		msg.Nak()
+		return
+	}
+
+	log.Warn("[NATS] Message dead-lettered", "reason", reason, "err", err)
+	msg.Ack()
+}
+
+// Publish `event` on `<EventsSubject>.<event name>` and wait until JetStream
+// stores it
+func (n *NATS) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the event `%s`: %w", event.Name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	_, js, err := n.connect()
+	if err != nil {
+		return err
+	}
+
+	if err := n.declareEvents(ctx, js); err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{
+		Subject: n.config.EventsSubject + "." + event.Name,
+		Header:  nats.Header{"Content-Type": []string{"application/json"}},
+		Data:    body,
+	}
+	if _, err := js.PublishMsg(ctx, msg, jetstream.WithMsgID(event.Id)); err != nil {
+		return fmt.Errorf("Failed to publish the event `%s`: %w", event.Name, err)
+	}
+
+	return nil
+}
+
+// Publish `body` on the subject `to` and wait until the server has received
+// it. Replies are not stored by JetStream: the requester must be subscribed.
+func (n *NATS) Reply(ctx context.Context, to string, correlationId string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	conn, _, err := n.connect()
+	if err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{
+		Subject: to,
+		Header:  nats.Header{"Content-Type": []string{"application/json"}},
+		Data:    body,
+	}
+	msg.Header.Set(headerCorrelationId, correlationId)
+
+	if err := conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("Reply to `%s` not published: %w", to, err)
+	}
+	if err := conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("Reply to `%s` not flushed: %w", to, err)
+	}
+
+	return nil
+}
+
+// Close the connection, if opened
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		return nil
+	}
+
+	err := n.conn.Drain()
+	n.conn, n.js, n.eventsDeclared = nil, nil, false
+	return err
+}
+
+// Returns the connection and its JetStream context, connecting on the first
+// call. The connection is shared by the consumer and the publisher, and the
+// client reconnects it forever.
+func (n *NATS) connect() (*nats.Conn, jetstream.JetStream, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn != nil {
+		return n.conn, n.js, nil
+	}
+
+	conn, err := nats.Connect(n.config.URL,
+		nats.Name("acmesky-workers"),
+		nats.MaxReconnects(-1),
+		// The state is updated only while consuming
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				err = errors.New("Disconnected")
+			}
+			if n.state.running() == nil {
+				n.state.set(StateConnecting, err)
+			}
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			if n.state.running() == nil {
+				n.state.set(StateConnected, nil)
+			}
+		}),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("Failed to open JetStream: %w", err)
+	}
+
+	n.conn, n.js = conn, js
+	return conn, js, nil
+}
+
+// Declare the stream of the events, once
+func (n *NATS) declareEvents(ctx context.Context, js jetstream.JetStream) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.eventsDeclared {
+		return nil
+	}
+
+	stream := jetstream.StreamConfig{
+		Name:     n.config.EventsStream,
+		Subjects: []string{n.config.EventsSubject + ".>"},
+		Storage:  jetstream.FileStorage,
+	}
+	if _, err := js.CreateOrUpdateStream(ctx, stream); err != nil {
+		return fmt.Errorf("Failed to declare the stream `%s`: %w", stream.Name, err)
+	}
+
+	n.eventsDeclared = true
+	return nil
+}
@@ -0,0 +1,55 @@
+package message
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acme-sky/workers/internal/db"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/acme-sky/workers/internal/models"
+)
+
+// Message of the payments sent by the bank
+const paymentMessage = "CM_Payment_Response"
+
+// Returns true if `body` confirms a payment
+func isPaymentConfirmed(body MessageBody) bool {
+	return body.Name == paymentMessage && body.Payload["payment_status"] == "OK"
+}
+
+// Mark the offer of a confirmed payment as paid, then count it and emit
+// `payment.completed`. The offer is updated only if it's not paid yet, so a
+// payment sent again, e.g. by a bank retrying its callback, is counted once.
+func paymentCompleted(ctx context.Context, payload map[string]interface{}) {
+	logger := logging.FromContext(ctx).With("offer_id", payload["offer_id"])
+
+	conn, err := db.WithContext(ctx)
+	if err != nil {
+		logger.Error("[Message] Payment not saved", "err", err)
+		return
+	}
+
+	var offer models.Offer
+	if err := conn.Where("id = ?", payload["offer_id"]).First(&offer).Error; err != nil {
+		logger.Error("[Message] Offer of the payment not found", "err", err)
+		return
+	}
+
+	updated := conn.Model(&models.Offer{}).Where("id = ? AND payment_paid = ?", offer.Id, false).Update("payment_paid", true)
+	if updated.Error != nil {
+		logger.Error("[Message] Payment not saved", "err", updated.Error)
+		return
+	}
+	if updated.RowsAffected == 0 {
+		logger.Info("[Message] Offer already paid")
+		return
+	}
+
+	metrics.PaymentsCompleted.Inc()
+	Emit(ctx, fmt.Sprintf("offer/%d", offer.Id), EventPaymentCompleted, map[string]interface{}{
+		"offer_id":   offer.Id,
+		"user_id":    offer.UserId,
+		"journey_id": offer.JourneyId,
+	})
+}
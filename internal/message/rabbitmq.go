@@ -0,0 +1,312 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Max time to wait for the broker to confirm an event or a reply
+var publishTimeout = 5 * time.Second
+
+// RabbitMQ transport. The consumer declares the durable `topology` and
+// dead-letters the rejected messages. Events and replies are published with
+// publisher confirms, on a connection opened on the first publish and again
+// after it's closed. Replies are mandatory, so a reply to a missing queue is
+// an error.
+type RabbitMQ struct {
+	uri         string
+	topology    Topology
+	maxAttempts int
+	state       consumerState
+
+	// Connection used to publish the events and the replies
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	closed  chan *amqp.Error
+	returns chan amqp.Return
+}
+
+var _ Transport = (*RabbitMQ)(nil)
+
+// Returns a RabbitMQ transport to the broker at `uri`
+func NewRabbitMQ(uri string, topology Topology, maxAttempts int) *RabbitMQ {
+	return &RabbitMQ{
+		uri:         uri,
+		topology:    topology,
+		maxAttempts: maxAttempts,
+		state:       consumerState{name: "RabbitMQ", state: StateStopped},
+	}
+}
+
+func (r *RabbitMQ) Name() string {
+	return "rabbitmq"
+}
+
+func (r *RabbitMQ) Status() error {
+	return r.state.status()
+}
+
+func (r *RabbitMQ) Running() error {
+	return r.state.running()
+}
+
+// Consume the messages of the queue until `ctx` is done. When the connection
+// or the channel is closed, e.g. by a broker restart, it reconnects with an
+// exponential backoff and declares the topology and the consumer again.
+func (r *RabbitMQ) Consume(ctx context.Context, handler Handler) {
+	reconnect(ctx, &r.state, func(ctx context.Context) (bool, error) {
+		return r.consume(ctx, handler)
+	})
+}
+
+// Connect, declare the topology and consume the queue messages until `ctx` is
+// done or the connection or the channel is closed. It returns true if the
+// consumer has been registered, and the error which stopped it.
+func (r *RabbitMQ) consume(ctx context.Context, handler Handler) (bool, error) {
+	conn, err := amqp.Dial(r.uri)
+	if err != nil {
+		return false, fmt.Errorf("Failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, fmt.Errorf("Failed to open a channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := r.topology.declare(ch); err != nil {
+		return false, err
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return false, fmt.Errorf("Failed to set QoS: %w", err)
+	}
+
+	// The retried and dead-lettered messages are acknowledged only once their
+	// copy is confirmed
+	if err := ch.Confirm(false); err != nil {
+		return false, fmt.Errorf("Failed to enable publisher confirms: %w", err)
+	}
+
+	msgs, err := ch.Consume(r.topology.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to register a consumer: %w", err)
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	log.Info("[RabbitMQ] Connected, consuming messages")
+	r.state.set(StateConnected, nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case err := <-connClosed:
+			return true, closeError("Connection closed", err)
+		case err := <-chClosed:
+			return true, closeError("Channel closed", err)
+		case d, ok := <-msgs:
+			if !ok {
+				return true, errors.New("Consumer channel closed")
+			}
+			r.handle(ctx, ch, d, handler)
+		}
+	}
+}
+
+// Returns an error for a closed connection or channel. `err` is nil when it
+// has been closed without an error.
+func closeError(message string, err *amqp.Error) error {
+	if err == nil {
+		return errors.New(message)
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// Handle the delivery `d` and acknowledge it. Rejected messages are
+// dead-lettered, while the ones which fail with a transient error are retried
+// up to `maxAttempts` deliveries.
+func (r *RabbitMQ) handle(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, handler Handler) {
+	err := handler(ctx, Delivery{
+		Body:          d.Body,
+		Attempt:       attempts(d),
+		MaxAttempts:   r.maxAttempts,
+		ReplyTo:       d.ReplyTo,
+		CorrelationId: d.CorrelationId,
+	})
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+
+	var rejected *RejectError
+	if errors.As(err, &rejected) {
+		deadLetter(ctx, ch, r.topology, d, rejected.Reason, rejected.Err)
+		return
+	}
+
+	if !retry(ctx, ch, r.topology, d, r.maxAttempts) {
+		deadLetter(ctx, ch, r.topology, d, ReasonPublishFailed, err)
+	}
+}
+
+// Publish `event` to the events exchange, with the event name as routing key,
+// and wait until the broker confirms it
+func (r *RabbitMQ) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the event `%s`: %w", event.Name, err)
+	}
+
+	publishing := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.Id,
+		Timestamp:    event.OccurredAt,
+		Type:         event.Name,
+		Body:         body,
+	}
+
+	if err := r.publish(ctx, r.topology.EventsExchange, event.Name, false, publishing); err != nil {
+		return fmt.Errorf("Event `%s` not published: %w", event.Name, err)
+	}
+
+	return nil
+}
+
+// Publish `body` to the reply queue `to`, through the default exchange, and
+// wait until the broker confirms it. `to` is a named queue or a direct
+// reply-to pseudo queue, `amq.rabbitmq.reply-to.*`, which can be published to
+// from any channel. A reply which can't be routed, e.g. because the requester
+// is gone, is returned by the broker and it's an error.
+func (r *RabbitMQ) Reply(ctx context.Context, to string, correlationId string, body []byte) error {
+	publishing := amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: correlationId,
+		Timestamp:     time.Now().UTC(),
+		Body:          body,
+	}
+
+	if err := r.publish(ctx, "", to, true, publishing); err != nil {
+		return fmt.Errorf("Reply to `%s` not published: %w", to, err)
+	}
+
+	return nil
+}
+
+// Publish `publishing` to `exchange` with the routing `key` and wait until the
+// broker confirms it. A `mandatory` publishing which is not routed to a queue
+// is returned before it's confirmed, so it's an error.
+func (r *RabbitMQ) publish(ctx context.Context, exchange string, key string, mandatory bool, publishing amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, err := r.channel()
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, false, publishing)
+	if err != nil {
+		r.reset()
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// The confirmation can't be matched with the next publishings anymore
+		r.reset()
+		return fmt.Errorf("Not confirmed: %w", err)
+	}
+	if !acked {
+		return errors.New("Rejected by the broker")
+	}
+
+	// The broker sends the return before the confirmation, and the publishings
+	// are serialized by `r.mu`, so a pending return is the one of this
+	// publishing
+	select {
+	case returned := <-r.returns:
+		return fmt.Errorf("Returned by the broker: %s", returned.ReplyText)
+	default:
+	}
+
+	return nil
+}
+
+// Close the connection used to publish the events and the replies, if opened
+func (r *RabbitMQ) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+
+	err := r.conn.Close()
+	r.conn, r.ch, r.closed, r.returns = nil, nil, nil, nil
+	return err
+}
+
+// Returns the channel in confirm mode used to publish the events and the
+// replies, connecting again if it has been closed
+func (r *RabbitMQ) channel() (*amqp.Channel, error) {
+	if r.ch != nil {
+		select {
+		case <-r.closed:
+			r.reset()
+		default:
+			return r.ch, nil
+		}
+	}
+
+	conn, err := amqp.Dial(r.uri)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to open a channel: %w", err)
+	}
+
+	if err := r.topology.declareEvents(ch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to enable publisher confirms: %w", err)
+	}
+
+	r.conn, r.ch = conn, ch
+	r.closed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	r.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	return ch, nil
+}
+
+// Close the connection used to publish the events, so the next publish
+// connects again
+func (r *RabbitMQ) reset() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn, r.ch, r.closed, r.returns = nil, nil, nil, nil
+}
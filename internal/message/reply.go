@@ -0,0 +1,105 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/entities"
+)
+
+// Process variable with the reply address of the request which started the
+// process, set by `Process`
+const replyVariable = "reply"
+
+// Reply address of a request
+type ReplyAddress struct {
+	To            string `json:"to"`
+	CorrelationId string `json:"correlation_id"`
+}
+
+// Body sent to the reply address once the step completes
+type ReplyBody struct {
+	// Type of the job which completed the request, e.g.
+	// `TM_Ack_Flight_Request_Save`
+	JobType string `json:"job_type"`
+
+	// Variables returned by the job
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Reply sent by a transport
+type Reply struct {
+	To            string
+	CorrelationId string
+	Body          []byte
+}
+
+// Send the variables returned by the handler to the reply address of the
+// request which started the process, if any. The reply variable is cleared so
+// the next steps don't reply again. A failed reply doesn't fail the job: the
+// API times out the request instead.
+func Replier() acmejob.Middleware {
+	return func(next acmejob.HandlerFunc) acmejob.HandlerFunc {
+		return func(ctx context.Context, job entities.Job) (map[string]interface{}, error) {
+			variables, err := next(ctx, job)
+			if err != nil {
+				return variables, err
+			}
+
+			address, ok := replyAddress(job)
+			if !ok {
+				return variables, nil
+			}
+
+			logger := logging.FromContext(ctx).With("reply_to", address.To, "correlation_id", address.CorrelationId)
+
+			result := make(map[string]interface{}, len(variables))
+			for name, value := range variables {
+				if name != replyVariable {
+					result[name] = value
+				}
+			}
+
+			body, err := json.Marshal(ReplyBody{JobType: job.GetType(), Variables: result})
+			switch {
+			case err != nil:
+				logger.Error("Failed to encode the reply", "err", err)
+			case transport == nil:
+				logger.Warn("No transport to send the reply")
+			default:
+				if err := transport.Reply(ctx, address.To, address.CorrelationId, body); err != nil {
+					logger.Error("Failed to send the reply", "err", err)
+				} else {
+					logger.Info("Sent the reply")
+				}
+			}
+
+			if variables == nil {
+				variables = make(map[string]interface{})
+			}
+			variables[replyVariable] = nil
+
+			return variables, nil
+		}
+	}
+}
+
+// Returns the reply address of the `job` process, if it's been started by a
+// request
+func replyAddress(job entities.Job) (ReplyAddress, bool) {
+	var variables struct {
+		Reply *ReplyAddress `json:"reply"`
+	}
+
+	if err := json.Unmarshal([]byte(job.GetVariables()), &variables); err != nil {
+		return ReplyAddress{}, false
+	}
+
+	if variables.Reply == nil || variables.Reply.To == "" {
+		return ReplyAddress{}, false
+	}
+
+	return *variables.Reply, true
+}
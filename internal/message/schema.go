@@ -0,0 +1,241 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	acmejob "github.com/acme-sky/workers/internal/job"
+)
+
+// Time of a payload, an RFC 3339 time or a `YYYY-MM-DD` date like the ones of
+// the date fields of the forms
+type DateTime struct {
+	time.Time
+}
+
+func (t *DateTime) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		parsed, err = time.Parse(time.DateOnly, value)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid time `%s`", value)
+	}
+
+	t.Time = parsed
+	return nil
+}
+
+// Payload of `CM_New_Request_Save_Flight`, a new flight interest of a user.
+// Times are RFC 3339 or `YYYY-MM-DD` dates, the latter are saved at midnight
+// UTC by `models.ValidateInterest`. `user_id` is optional: the interest is
+// saved for the user 1 without it.
+type NewRequestSaveFlightPayload struct {
+	Flight1DepartureTime    DateTime  `json:"flight1_departure_time" binding:"required"`
+	Flight1DepartureAirport string    `json:"flight1_departure_airport" binding:"required"`
+	Flight1ArrivalTime      DateTime  `json:"flight1_arrival_time" binding:"required"`
+	Flight1ArrivalAirport   string    `json:"flight1_arrival_airport" binding:"required"`
+	Flight2DepartureTime    *DateTime `json:"flight2_departure_time"`
+	Flight2DepartureAirport *string   `json:"flight2_departure_airport"`
+	Flight2ArrivalTime      *DateTime `json:"flight2_arrival_time"`
+	Flight2ArrivalAirport   *string   `json:"flight2_arrival_airport"`
+	UserId                  *int      `json:"user_id"`
+}
+
+// Payload of `CM_Check_Offer`, an offer token sent by a user
+type CheckOfferPayload struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Payload of `CM_Payment_Response`, the result of a payment sent by the bank
+type PaymentResponsePayload struct {
+	OfferId       int    `json:"offer_id" binding:"required"`
+	PaymentStatus string `json:"payment_status" binding:"required"`
+}
+
+// Flight of a last minute offer sent by an airline
+type LastMinuteFlight struct {
+	Airline          string    `json:"airline" binding:"required"`
+	Code             string    `json:"code" binding:"required"`
+	DepartureTime    time.Time `json:"departure_time" binding:"required"`
+	DepartureAirport string    `json:"departure_airport" binding:"required"`
+	ArrivalTime      time.Time `json:"arrival_time" binding:"required"`
+	ArrivalAirport   string    `json:"arrival_airport" binding:"required"`
+	Cost             float64   `json:"cost" binding:"required"`
+}
+
+// Payload of `CM_Received_Last_Minute_Offer`
+type LastMinuteOfferPayload struct {
+	Flight LastMinuteFlight `json:"flight" binding:"required"`
+}
+
+// Payload types of the messages accepted from RabbitMQ, by message name.
+// Messages with any other name are rejected.
+var schemas = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}{
+	types: map[string]reflect.Type{
+		"CM_New_Request_Save_Flight":    reflect.TypeOf(NewRequestSaveFlightPayload{}),
+		"CM_Check_Offer":                reflect.TypeOf(CheckOfferPayload{}),
+		"CM_Payment_Response":           reflect.TypeOf(PaymentResponsePayload{}),
+		"CM_Received_Last_Minute_Offer": reflect.TypeOf(LastMinuteOfferPayload{}),
+	},
+}
+
+// Returned by `Validate` for a message name which is not registered
+var ErrUnknownMessage = errors.New("Message is not allowed")
+
+// Payload which doesn't match the schema of its message
+type ValidationError struct {
+	Message string
+
+	// One line for each invalid field, e.g. "`token` is required"
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid payload for `%s`: %s", e.Message, strings.Join(e.Problems, "; "))
+}
+
+// Accept the messages `name` from RabbitMQ, with a payload matching the
+// struct `T`
+func Register[T any](name string) {
+	schemas.Lock()
+	defer schemas.Unlock()
+
+	schemas.types[name] = reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Returns true if the messages `name` are accepted
+func Registered(name string) bool {
+	schemas.RLock()
+	defer schemas.RUnlock()
+
+	_, ok := schemas.types[name]
+	return ok
+}
+
+// Check `payload` against the schema registered for the message `name`.
+// Fields which are not in the struct are not allowed, so a producer can't set
+// arbitrary variables in the process. Fields tagged with `binding:"required"`
+// can't be missing or empty, and every field must decode into its type.
+// Nested structs are checked the same way.
+func Validate(name string, payload map[string]interface{}) error {
+	schemas.RLock()
+	schema, ok := schemas.types[name]
+	schemas.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: `%s`", ErrUnknownMessage, name)
+	}
+
+	if problems := validateStruct(schema, payload, ""); len(problems) > 0 {
+		return &ValidationError{Message: name, Problems: problems}
+	}
+
+	return nil
+}
+
+// Returns the problems of `payload` against the struct `schema`. Field names
+// are prefixed by `prefix`, e.g. `flight.`.
+func validateStruct(schema reflect.Type, payload map[string]interface{}, prefix string) []string {
+	var problems []string
+
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+		if field.IsExported() {
+			fields[acmejob.VariableName(field)] = field
+		}
+	}
+
+	var unknown []string
+	for name := range payload {
+		if _, ok := fields[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("`%s%s` is not allowed", prefix, name))
+	}
+
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := acmejob.VariableName(field)
+		required := field.Tag.Get("binding") == "required"
+
+		value, ok := payload[name]
+		if !ok || value == nil {
+			if required {
+				problems = append(problems, fmt.Sprintf("`%s%s` is required", prefix, name))
+			}
+			continue
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && isNested(field.Type) {
+			problems = append(problems, validateStruct(field.Type, nested, prefix+name+".")...)
+			continue
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("`%s%s` is invalid", prefix, name))
+			continue
+		}
+
+		decoded := reflect.New(field.Type)
+		if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("`%s%s` must be %s", prefix, name, describe(field.Type)))
+			continue
+		}
+
+		if required && decoded.Elem().IsZero() {
+			problems = append(problems, fmt.Sprintf("`%s%s` can't be empty", prefix, name))
+		}
+	}
+
+	return problems
+}
+
+// Returns true if the fields of `t` are checked one by one. Types decoded
+// from a JSON object on their own, like `time.Time`, are not.
+func isNested(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	return !reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem())
+}
+
+// Returns the expected JSON value of `t` for the validation report
+func describe(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return "an RFC 3339 time"
+	case t == reflect.TypeOf(DateTime{}):
+		return "an RFC 3339 time or a YYYY-MM-DD date"
+	case isNested(t):
+		return "an object"
+	case t.Kind() == reflect.Pointer:
+		return describe(t.Elem())
+	}
+
+	return t.String()
+}
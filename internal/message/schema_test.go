@@ -0,0 +1,62 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateNewRequestSaveFlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "RFC 3339 times without user",
+			payload: map[string]interface{}{
+				"flight1_departure_time":    "2024-05-01T10:00:00Z",
+				"flight1_departure_airport": "BLQ",
+				"flight1_arrival_time":      "2024-05-01T12:00:00Z",
+				"flight1_arrival_airport":   "CDG",
+			},
+		},
+		{
+			name: "date without time",
+			payload: map[string]interface{}{
+				"flight1_departure_time":    "2024-05-01",
+				"flight1_departure_airport": "BLQ",
+				"flight1_arrival_time":      "2024-05-01T12:00:00Z",
+				"flight1_arrival_airport":   "CDG",
+				"user_id":                   7,
+			},
+		},
+		{
+			name: "invalid time",
+			payload: map[string]interface{}{
+				"flight1_departure_time":    "01/05/2024",
+				"flight1_departure_airport": "BLQ",
+				"flight1_arrival_time":      "2024-05-01T12:00:00Z",
+				"flight1_arrival_airport":   "CDG",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate("CM_New_Request_Save_Flight", tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePaymentResponseRequiresOffer(t *testing.T) {
+	err := Validate("CM_Payment_Response", map[string]interface{}{"payment_status": "OK"})
+
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 1 || invalid.Problems[0] != "`offer_id` is required" {
+		t.Fatalf("error = %v, want only `offer_id` is required", err)
+	}
+}
@@ -0,0 +1,131 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Defaults of the RabbitMQ topology
+const (
+	defaultExchange       = "acme_sky"
+	defaultQueue          = "acme_messages"
+	defaultBindings       = "message.#"
+	defaultEventsExchange = "acme_sky.events"
+)
+
+// RabbitMQ exchanges, queues and bindings used by the workers. Everything is
+// durable, so the messages published while the workers are down are consumed
+// when they start again.
+type Topology struct {
+	// Topic exchange bound to `Queue`
+	Exchange string
+
+	// Queue consumed by the workers. Messages published to the default
+	// exchange with this routing key are consumed too.
+	Queue string
+
+	// Routing keys bound from `Exchange` to `Queue`
+	Bindings []string
+
+	// Topic exchange where the domain events are published, with the event
+	// name as routing key
+	EventsExchange string
+}
+
+// Returns the topology from `conf`, e.g. `RABBITMQ_EXCHANGE=acme_sky`,
+// `RABBITMQ_QUEUE=acme_messages`, `RABBITMQ_BINDINGS=message.#,booking.*` and
+// `RABBITMQ_EVENTS_EXCHANGE=acme_sky.events`
+func TopologyFromConfig(conf *koanf.Koanf) Topology {
+	topology := Topology{
+		Exchange:       conf.String("rabbitmq.exchange"),
+		Queue:          conf.String("rabbitmq.queue"),
+		EventsExchange: conf.String("rabbitmq.events.exchange"),
+	}
+
+	if len(topology.Exchange) == 0 {
+		topology.Exchange = defaultExchange
+	}
+	if len(topology.Queue) == 0 {
+		topology.Queue = defaultQueue
+	}
+	if len(topology.EventsExchange) == 0 {
+		topology.EventsExchange = defaultEventsExchange
+	}
+
+	bindings := conf.String("rabbitmq.bindings")
+	if len(bindings) == 0 {
+		bindings = defaultBindings
+	}
+	for _, key := range strings.Split(bindings, ",") {
+		if key = strings.TrimSpace(key); len(key) > 0 {
+			topology.Bindings = append(topology.Bindings, key)
+		}
+	}
+
+	return topology
+}
+
+// Exchange for the messages which can't be delivered to Zeebe
+func (t Topology) DeadLetterExchange() string {
+	return t.Queue + ".dlx"
+}
+
+// Queue bound to `DeadLetterExchange`
+func (t Topology) DeadLetterQueue() string {
+	return t.Queue + ".dead"
+}
+
+// Declare the exchange, the consumed queue with its bindings and the
+// dead-letter exchange and queue. Messages rejected without requeue are
+// dead-lettered by RabbitMQ too.
+func (t Topology) declare(ch *amqp.Channel) error {
+	if err := t.declareDeadLetter(ch); err != nil {
+		return err
+	}
+
+	if err := ch.ExchangeDeclare(t.Exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare the exchange `%s`: %w", t.Exchange, err)
+	}
+
+	args := amqp.Table{"x-dead-letter-exchange": t.DeadLetterExchange()}
+	if _, err := ch.QueueDeclare(t.Queue, true, false, false, false, args); err != nil {
+		return fmt.Errorf("Failed to declare the queue `%s`: %w", t.Queue, err)
+	}
+
+	for _, key := range t.Bindings {
+		if err := ch.QueueBind(t.Queue, key, t.Exchange, false, nil); err != nil {
+			return fmt.Errorf("Failed to bind `%s` to the queue: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// Declare the dead-letter exchange and queue
+func (t Topology) declareDeadLetter(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(t.DeadLetterExchange(), amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare the dead-letter exchange: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(t.DeadLetterQueue(), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare the dead-letter queue: %w", err)
+	}
+
+	if err := ch.QueueBind(t.DeadLetterQueue(), "", t.DeadLetterExchange(), false, nil); err != nil {
+		return fmt.Errorf("Failed to bind the dead-letter queue: %w", err)
+	}
+
+	return nil
+}
+
+// Declare the exchange of the domain events
+func (t Topology) declareEvents(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(t.EventsExchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare the events exchange `%s`: %w", t.EventsExchange, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/knadh/koanf/v2"
+)
+
+// Transport of the inbound process messages and of the outbound domain events
+type Transport interface {
+	// Name of the transport, e.g. `rabbitmq`
+	Name() string
+
+	// Consume the inbound messages with `handler` until `ctx` is done. A
+	// transport reconnects on its own, so it returns only when `ctx` is done.
+	Consume(ctx context.Context, handler Handler)
+
+	// Publish `event` and wait until the broker has stored it
+	Publish(ctx context.Context, event Event) error
+
+	// Send `body` to the reply address `to` of a request, with its
+	// `correlationId`
+	Reply(ctx context.Context, to string, correlationId string, body []byte) error
+
+	// Returns an error if the consumer is not connected
+	Status() error
+
+	// Returns an error if the consumer is stopped. A consumer which is
+	// reconnecting is still running.
+	Running() error
+
+	// Close the connections used to publish the events
+	Close() error
+}
+
+// Message received by a transport
+type Delivery struct {
+	Body []byte
+
+	// Delivery attempt, starting from 1
+	Attempt int
+
+	// Last attempt before the message is dead-lettered
+	MaxAttempts int
+
+	// Reply address and correlation id of a request, empty if no reply is
+	// expected
+	ReplyTo       string
+	CorrelationId string
+}
+
+// Handle an inbound message. A nil error acknowledges it, a `*RejectError`
+// dead-letters it and any other error is transient: the message is delivered
+// again, up to `MaxAttempts` deliveries.
+type Handler func(ctx context.Context, d Delivery) error
+
+// Error of a message which must be dead-lettered without retries
+type RejectError struct {
+	// One of the `Reason*` constants
+	Reason string
+	Err    error
+}
+
+func (e *RejectError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Returns an error which dead-letters the message for `reason`
+func Reject(reason string, err error) error {
+	return &RejectError{Reason: reason, Err: err}
+}
+
+// Number of deliveries of a message before it's dead-lettered after transient
+// failures, e.g. `MESSAGE_MAX_ATTEMPTS=5`. `RABBITMQ_MAX_ATTEMPTS` is still
+// read if it's not set.
+var defaultMaxAttempts = 5
+
+// Backoff before reconnecting to a broker, doubled on every failed attempt up
+// to `maxReconnectBackoff`
+var (
+	initialReconnectBackoff = time.Second
+	maxReconnectBackoff     = 30 * time.Second
+)
+
+// Global variable but private
+var transport Transport = nil
+
+// Init the global transport from `conf`, e.g. `MESSAGE_TRANSPORT=nats`. It can
+// be `rabbitmq` (the default), `nats` or `memory`.
+func InitTransport(conf *koanf.Koanf) (Transport, error) {
+	maxAttempts := conf.Int("message.max.attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = conf.Int("rabbitmq.max.attempts")
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	switch name := conf.String("message.transport"); name {
+	case "", "rabbitmq":
+		transport = NewRabbitMQ(conf.String("rabbitmq.uri"), TopologyFromConfig(conf), maxAttempts)
+	case "nats":
+		transport = NewNATS(NATSConfigFromConfig(conf), maxAttempts)
+	case "memory":
+		transport = NewMemory(maxAttempts)
+	default:
+		return nil, fmt.Errorf("Invalid `MESSAGE_TRANSPORT` `%s`, it must be `rabbitmq`, `nats` or `memory`", name)
+	}
+
+	return transport, nil
+}
+
+// Return the instance or error if the transport is not initialized yet
+func GetTransport() (Transport, error) {
+	if transport == nil {
+		return nil, errors.New("You must call `InitTransport()` first.")
+	}
+	return transport, nil
+}
+
+// Replace the instance, e.g. with a `Memory` transport in tests. It returns
+// the previous one so it can be restored.
+func SetTransport(t Transport) Transport {
+	previous := transport
+	transport = t
+	return previous
+}
+
+// Close the global transport, if initialized
+func CloseTransport() error {
+	if transport == nil {
+		return nil
+	}
+	return transport.Close()
+}
+
+// Returns an error if the consumer of the global transport is not connected,
+// e.g. while it's reconnecting after a broker restart
+func Status() error {
+	if transport == nil {
+		return errors.New("Message transport is not initialized")
+	}
+	return transport.Status()
+}
+
+// Returns an error if the consumer of the global transport is stopped
+func Running() error {
+	if transport == nil {
+		return errors.New("Message transport is not initialized")
+	}
+	return transport.Running()
+}
+
+// State of a consumer
+type State string
+
+const (
+	StateStopped    State = "stopped"
+	StateConnecting State = "connecting"
+	StateConnected  State = "connected"
+)
+
+// State of the consumer of a transport, safe for concurrent use
+type consumerState struct {
+	// Name used in the errors, e.g. `RabbitMQ`
+	name string
+
+	mu    sync.Mutex
+	state State
+	// Last connection or channel error, if any
+	err error
+}
+
+func (c *consumerState) set(state State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = state
+	c.err = err
+}
+
+func (c *consumerState) status() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case StateConnected:
+		return nil
+	case StateConnecting:
+		if c.err != nil {
+			return fmt.Errorf("%s consumer is reconnecting: %s", c.name, c.err.Error())
+		}
+		return fmt.Errorf("%s consumer is connecting", c.name)
+	default:
+		return fmt.Errorf("%s consumer is not running", c.name)
+	}
+}
+
+func (c *consumerState) running() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != StateConnecting && c.state != StateConnected {
+		return fmt.Errorf("%s consumer is not running", c.name)
+	}
+
+	return nil
+}
+
+// Call `consume` until `ctx` is done, with an exponential backoff between the
+// calls. `consume` returns true if it has connected, so the backoff is reset,
+// and the error which stopped it.
+func reconnect(ctx context.Context, state *consumerState, consume func(ctx context.Context) (bool, error)) {
+	state.set(StateConnecting, nil)
+	defer state.set(StateStopped, nil)
+
+	backoff := initialReconnectBackoff
+	for {
+		connected, err := consume(ctx)
+		if ctx.Err() != nil {
+			log.Info("Closing the consumer", "transport", state.name)
+			return
+		}
+
+		if connected {
+			backoff = initialReconnectBackoff
+		}
+
+		state.set(StateConnecting, err)
+		log.Error("Consumer disconnected, reconnecting", "transport", state.name, "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Closing the consumer", "transport", state.name)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Prefix of every metric
+const namespace = "acmesky"
+
+// Jobs handled by the workers, by job type
+var (
+	JobsActivated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_activated_total",
+		Help:      "Number of activated jobs.",
+	}, []string{"job_type"})
+
+	JobsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_completed_total",
+		Help:      "Number of jobs completed by the handler.",
+	}, []string{"job_type"})
+
+	JobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Number of jobs failed by the handler, including BPMN errors.",
+	}, []string{"job_type"})
+
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Duration of the job handlers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"job_type"})
+)
+
+// Sources of the messages published to Zeebe: received from the broker or a
+// webhook, or sent by a job after its completion
+const (
+	SourceInbound = "inbound"
+	SourceJob     = "job"
+)
+
+// Messages received from RabbitMQ, by message name
+var (
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Number of messages consumed from RabbitMQ.",
+	}, []string{"message"})
+
+	MessagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_published_total",
+		Help:      "Number of messages published to Zeebe, by source.",
+	}, []string{"message", "source"})
+
+	MessagesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_rejected_total",
+		Help:      "Number of messages rejected because invalid or not published.",
+	}, []string{"message"})
+)
+
+// Domain events published to RabbitMQ, by event name
+var (
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Number of domain events confirmed by RabbitMQ.",
+	}, []string{"event"})
+
+	EventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_failed_total",
+		Help:      "Number of domain events not published or not confirmed.",
+	}, []string{"event"})
+)
+
+// Requests to external services, by dependency: `airline`, `bank`,
+// `prontogram`, `rent` and `geodistance`
+var (
+	OutboundRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_requests_total",
+		Help:      "Number of requests to external services.",
+	}, []string{"dependency", "status"})
+
+	OutboundDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbound_request_duration_seconds",
+		Help:      "Duration of the requests to external services.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"dependency"})
+)
+
+// Webhooks received from external services, by endpoint: `bank` and
+// `last_minute_offer`
+var InboundWebhooks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "inbound_webhooks_total",
+	Help:      "Number of webhooks received from external services.",
+}, []string{"endpoint", "status"})
+
+// Business events
+var (
+	OffersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "offers_created_total",
+		Help:      "Number of offers created for the users.",
+	})
+
+	TokensRedeemed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_redeemed_total",
+		Help:      "Number of offer tokens redeemed by the users.",
+	})
+
+	PaymentsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_completed_total",
+		Help:      "Number of offers paid by the users.",
+	})
+)
+
+// Observe a request to `dependency` started at `start`. `status` is the HTTP
+// status code, or any other result like `error`.
+func ObserveRequest(dependency string, start time.Time, status string) {
+	OutboundRequests.WithLabelValues(dependency, status).Inc()
+	OutboundDuration.WithLabelValues(dependency).Observe(time.Since(start).Seconds())
+}
+
+// Returns the status label of an HTTP response, or `error` if `err` is set
+func HTTPStatus(res *http.Response, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return fmt.Sprint(res.StatusCode)
+}
+
+// gRPC interceptor which observes every unary call as a request to
+// `dependency`
+func UnaryClientInterceptor(dependency string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		ObserveRequest(dependency, start, status.Code(err).String())
+
+		return err
+	}
+}
+
+// Handler which exposes the metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
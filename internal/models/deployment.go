@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// Deployment model, it stores the checksum of every resource deployed on Zeebe
+type Deployment struct {
+	Id        uint      `gorm:"column:id" json:"id"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	Resource  string    `gorm:"column:resource;index" json:"resource"`
+	Checksum  string    `gorm:"column:checksum" json:"checksum"`
+	Key       int64     `gorm:"column:key" json:"key"`
+}
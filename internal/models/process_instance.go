@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// States of a process instance created by the workers
+const (
+	InstanceActive   = "active"
+	InstanceCanceled = "canceled"
+
+	// Completed or canceled outside the workers: Zeebe doesn't know it anymore
+	InstanceEnded = "ended"
+)
+
+// ProcessInstance model, it stores the instances created by the workers on
+// start up and their state
+type ProcessInstance struct {
+	Id        uint       `gorm:"column:id" json:"id"`
+	CreatedAt time.Time  `gorm:"column:created_at" json:"created_at"`
+	ProcessId string     `gorm:"column:process_id;index" json:"process_id"`
+	Key       int64      `gorm:"column:key;index" json:"key"`
+	State     string     `gorm:"column:state;default:active" json:"state"`
+	EndedAt   *time.Time `gorm:"column:ended_at" json:"ended_at"`
+}
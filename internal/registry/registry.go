@@ -0,0 +1,68 @@
+// Package registry lists the jobs handled by the workers, so they can be
+// registered by `main` and by the tests.
+package registry
+
+import (
+	"time"
+
+	acmeskyHandlers "github.com/acme-sky/workers/internal/handlers/acmesky"
+	prontogramHandlers "github.com/acme-sky/workers/internal/handlers/prontogram"
+	userHandlers "github.com/acme-sky/workers/internal/handlers/user"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/message"
+)
+
+// Returns a new list of every job handled by the workers, with the task type
+// as name. Jobs which create records or book with external services use
+// `NoRetryPolicy`, since their handlers are not idempotent.
+func Jobs() []acmejob.Job {
+	return []acmejob.Job{
+		// ------------- USER -------------
+		// First part when an user expresses interest to monitor a flight
+		{Name: "TM_New_Request_Save_Flight", Handler: userHandlers.TMNewRequestSaveFlight, Message: &acmejob.MessageCommand{Name: "CM_New_Request_Save_Flight", CorrelationVariable: "user_id"}},
+		{Name: "TM_Check_Offer", Handler: userHandlers.TMCheckOffer, Message: &acmejob.MessageCommand{Name: "CM_Check_Offer", CorrelationVariable: "token"}},
+
+		// ------------- PRONTOGRAM -------------
+		{Name: "ST_Save_Info_On_Prontogram", Handler: acmejob.Typed(prontogramHandlers.STSaveInfoOnProntogram), Message: nil},
+		{Name: "TM_Propagate_Message_From_Prontogram", Handler: prontogramHandlers.TMPropagateMessageFromProntogram, Message: &acmejob.MessageCommand{Name: "Start_Received_New_Offer", CorrelationVariable: "offer.token"}},
+
+		// ------------- ACMESKY -------------
+		// First part of User Profile lane
+		{Name: "ST_Save_Flight", Handler: acmeskyHandlers.STSaveFlight, Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Ack_Flight_Request_Save", Handler: acmeskyHandlers.TMAckFlightRequestSave, Message: &acmejob.MessageCommand{Name: "CM_Ack_Flight_Request_Save", CorrelationVariable: "user_id"}, Middlewares: []acmejob.Middleware{message.Replier()}},
+
+		// Interests manager lane
+		{Name: "ST_Create_Journeys", Handler: acmeskyHandlers.STCreateJourneys},
+		{Name: "ST_Prepare_Offer", Handler: acmejob.Typed(acmeskyHandlers.STPrepareOffer), FetchVariables: []string{"journeys", "loopCounter"}, Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Send_Offer", Handler: acmeskyHandlers.TMSendOffer, Message: &acmejob.MessageCommand{Name: "CM_New_Message_For_Prontogram", CorrelationVariable: "offer.token"}},
+
+		// User profile lane: check offer
+		{Name: "ST_Retrieve_Offer", Handler: acmejob.Typed(acmeskyHandlers.STRetrieveOffer)},
+		{Name: "ST_Change_Offer_Status", Handler: acmejob.Typed(acmeskyHandlers.STChangeOfferStatus), FetchVariables: []string{"offer_id"}},
+		{Name: "TM_Error_On_Check_Offer", Handler: acmeskyHandlers.TMErrorOnCheckOffer, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Error", CorrelationVariable: "token"}, Middlewares: []acmejob.Middleware{message.Replier()}},
+
+		// User profile lane: book journey
+		// Message fields for TM_Book_Journey and TM_Ask_Payment_Link is `nil` because it comunicates with an hidden participant
+		{Name: "TM_Book_Journey", Handler: acmejob.Typed(acmeskyHandlers.TMBookJourney), Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Ask_Payment_Link", Handler: acmejob.Typed(acmeskyHandlers.TMAskPaymentLink), Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Send_Payment_Link", Handler: acmeskyHandlers.TMSendPaymentLink, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Link", CorrelationVariable: "token"}, Middlewares: []acmejob.Middleware{message.Replier()}},
+		{Name: "ST_Offer_Still_Valid", Handler: acmeskyHandlers.STOfferStillValid, FetchVariables: []string{"offer_id"}},
+		{Name: "TM_Error_On_Book_Journey", Handler: acmeskyHandlers.TMErrorOnBookJourney, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Error", CorrelationVariable: "token"}},
+		{Name: "TM_Invoice", Handler: acmeskyHandlers.TMInvoice, Message: &acmejob.MessageCommand{Name: "CM_Journey", CorrelationVariable: "token"}, Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Compute_Distance_User_Airport", Handler: acmejob.Typed(acmeskyHandlers.TMComputeDistanceUserAirport)},
+		{Name: "ST_Sort_Rent_Services", Handler: acmejob.Typed(acmeskyHandlers.STSortRentServices)},
+		{Name: "TM_Ask_For_Rent", Handler: acmejob.Typed(acmeskyHandlers.TMAskForRent), Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Invoice_And_Rent", Handler: acmeskyHandlers.TMInvoiceAndRent, Message: &acmejob.MessageCommand{Name: "CM_Journey_And_Rent", CorrelationVariable: "token"}, Retry: &acmejob.NoRetryPolicy},
+		{Name: "TM_Invoice_Rent_Error", Handler: acmeskyHandlers.TMInvoiceRentError, Message: &acmejob.MessageCommand{Name: "CM_Journey", CorrelationVariable: "token"}, Retry: &acmejob.NoRetryPolicy},
+
+		// User profile lane: flights manager
+		{Name: "ST_Save_Last_Minute_Offer", Handler: acmejob.Typed(acmeskyHandlers.STSaveLastMinuteOffer)},
+		{Name: "ST_Get_User_Interests", Handler: acmeskyHandlers.STGetUserInterests},
+		// Every activation makes up to two HTTP requests for each interest
+		{Name: "TM_Search_Flights_On_Airline", Handler: acmejob.Typed(acmeskyHandlers.TMSearchFlightsOnAirline), MaxJobsActive: 8, Concurrency: 8, Timeout: 10 * time.Minute, FetchVariables: []string{"airlines", "interests", "loopCounter"}},
+		{Name: "ST_Save_Flights_As_Available", Handler: acmejob.Typed(acmeskyHandlers.STSaveFlightsAsAvailable), FetchVariables: []string{"flights"}},
+
+		// Task of the test process in `acmesky.bpmn`, which has nothing to do
+		{Name: "TEST", Handler: acmejob.Noop},
+	}
+}
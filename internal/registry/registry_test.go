@@ -0,0 +1,156 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/acme-sky/workers/internal/db"
+	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/job/jobtest"
+	"github.com/acme-sky/workers/internal/models"
+	"github.com/acme-sky/workers/internal/registry"
+)
+
+func TestJobsMatchBPMN(t *testing.T) {
+	resources, err := acmejob.FindResources("../../bpmn/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := acmejob.ValidateRegistry(resources, registry.Jobs(), false); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// Start a worker on `gateway` for every registered job
+func handleAll(t *testing.T, gateway *jobtest.Gateway) {
+	t.Helper()
+
+	client := gateway.Client(t)
+	for _, job := range registry.Jobs() {
+		worker := job.Handle(client)
+		t.Cleanup(worker.Close)
+	}
+}
+
+// Fake airline which returns `flight` for the requests matching its departure
+func airlineServer(t *testing.T, flight map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filter map[string]string
+		if r.URL.Path != "/flights/filter/" || json.NewDecoder(r.Body).Decode(&filter) != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		departure := flight["departure_airport"].(map[string]interface{})["code"]
+		data := []map[string]interface{}{}
+		if filter["departure_airport"] == departure && filter["departure_time"] == flight["departure_time"] {
+			data = append(data, flight)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": len(data), "data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// Run the flow from the interest of a user to the offer sent to Prontogram,
+// one job after the other, with the registered workers
+func TestScenarioInterestToOffer(t *testing.T) {
+	jobtest.UseDatabase(t)
+	conn, _ := db.GetDb()
+
+	name := fmt.Sprintf("scenario%d", jobtest.NextKey())
+	user := models.User{Name: name, Username: name, Email: name + "@example.com", Password: "password"}
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	departure := time.Now().AddDate(0, 0, 10).Truncate(time.Second).UTC()
+	arrival := departure.Add(3 * time.Hour)
+	server := airlineServer(t, map[string]interface{}{
+		"code":              "SC1234",
+		"departure_airport": map[string]interface{}{"code": "BLQ"},
+		"departure_time":    departure.Format(time.RFC3339),
+		"arrival_airport":   map[string]interface{}{"code": "CPH"},
+		"arrival_time":      arrival.Format(time.RFC3339),
+		"cost":              120.5,
+	})
+
+	airline := models.Airline{Name: "Scenario Airline", Endpoint: server.URL}
+	if err := conn.Create(&airline).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	gateway := jobtest.NewGateway(t)
+	handleAll(t, gateway)
+
+	// The user expresses an interest
+	instance := gateway.CreateInstance("Process_ACME", map[string]interface{}{
+		"user_id":                   user.ID,
+		"flight1_departure_airport": "BLQ",
+		"flight1_departure_time":    departure.Format(time.RFC3339),
+		"flight1_arrival_airport":   "CPH",
+		"flight1_arrival_time":      arrival.Format(time.RFC3339),
+	})
+	if result := gateway.Step(t, instance, "ST_Save_Flight"); result.Kind != jobtest.Complete {
+		t.Fatalf("ST_Save_Flight: %+v", result)
+	}
+
+	// The flights manager searches the interests on the airline
+	if result := gateway.Step(t, instance, "ST_Get_User_Interests"); result.Kind != jobtest.Complete {
+		t.Fatalf("ST_Get_User_Interests: %+v", result)
+	}
+	gateway.MergeVariables(instance, map[string]interface{}{"airlines": []models.Airline{airline}, "loopCounter": 1})
+
+	result := gateway.Step(t, instance, "TM_Search_Flights_On_Airline")
+	if result.Kind != jobtest.Complete {
+		t.Fatalf("TM_Search_Flights_On_Airline: %+v", result)
+	}
+	if flights, _ := result.Variables["flights"].([]interface{}); len(flights) != 1 {
+		t.Fatalf("found flights = %v, want the one of the airline", result.Variables["flights"])
+	}
+
+	if result := gateway.Step(t, instance, "ST_Save_Flights_As_Available"); result.Kind != jobtest.Complete {
+		t.Fatalf("ST_Save_Flights_As_Available: %+v", result)
+	}
+
+	// The interests manager creates the journey and its offer
+	if result := gateway.Step(t, instance, "ST_Create_Journeys"); result.Kind != jobtest.Complete {
+		t.Fatalf("ST_Create_Journeys: %+v", result)
+	}
+
+	var journey models.Journey
+	if err := conn.Where("user_id = ?", user.ID).Preload("Flight1").First(&journey).Error; err != nil {
+		t.Fatalf("journey not created: %s", err.Error())
+	}
+	if journey.Flight1.Code != "SC1234" || journey.Flight2Id != nil {
+		t.Fatalf("journey = %+v, want the flight SC1234 only", journey)
+	}
+	gateway.MergeVariables(instance, map[string]interface{}{"journeys": []uint{journey.Id}, "loopCounter": 1})
+
+	result = gateway.Step(t, instance, "ST_Prepare_Offer")
+	if result.Kind != jobtest.Complete {
+		t.Fatalf("ST_Prepare_Offer: %+v", result)
+	}
+	offer, _ := result.Variables["offer"].(map[string]interface{})
+	token, _ := offer["token"].(string)
+	if len(token) == 0 {
+		t.Fatalf("offer = %v, want a token", result.Variables["offer"])
+	}
+
+	// The offer is sent to Prontogram, correlated by its token
+	if result := gateway.Step(t, instance, "TM_Send_Offer"); result.Kind != jobtest.Complete {
+		t.Fatalf("TM_Send_Offer: %+v", result)
+	}
+	message := gateway.WaitMessage(t, "CM_New_Message_For_Prontogram", 0)
+	if message.CorrelationKey != token {
+		t.Errorf("message correlation key = %q, want the offer token %q", message.CorrelationKey, token)
+	}
+}
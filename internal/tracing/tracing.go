@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Start a Sentry span as child of the span in `ctx`. Without a parent span,
+// e.g. outside of a job, the span is not sampled so no orphan transaction is
+// sent. Always call `Finish()` on the returned span.
+func StartSpan(ctx context.Context, operation string, description string) *sentry.Span {
+	options := []sentry.SpanOption{sentry.WithDescription(description)}
+	if sentry.SpanFromContext(ctx) == nil {
+		options = append(options, sentry.WithSpanSampled(sentry.SampledFalse))
+	}
+
+	return sentry.StartSpan(ctx, operation, options...)
+}
+
+// Set the status of `span` from `err`
+func SetError(span *sentry.Span, err error) {
+	if err != nil {
+		span.Status = sentry.SpanStatusInternalError
+		span.SetData("error", err.Error())
+	} else {
+		span.Status = sentry.SpanStatusOK
+	}
+}
+
+// Send `req` with `client` inside an `http.client` span. The trace headers
+// are added to the request, so the called service can continue the trace.
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	span := StartSpan(req.Context(), "http.client", fmt.Sprintf("%s %s", req.Method, req.URL.String()))
+	defer span.Finish()
+
+	req.Header.Set(sentry.SentryTraceHeader, span.ToSentryTrace())
+	req.Header.Set(sentry.SentryBaggageHeader, span.ToBaggage())
+
+	res, err := client.Do(req)
+	if err != nil {
+		SetError(span, err)
+		return nil, err
+	}
+
+	span.SetData("http.response.status_code", fmt.Sprint(res.StatusCode))
+	span.Status = sentry.HTTPtoSpanStatus(res.StatusCode)
+
+	return res, nil
+}
+
+// gRPC interceptor which runs every unary call inside a `grpc.client` span
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		span := StartSpan(ctx, "grpc.client", method)
+		defer span.Finish()
+
+		err := invoker(span.Context(), method, req, reply, cc, opts...)
+		SetError(span, err)
+		if err != nil {
+			span.SetData("grpc.status_code", status.Code(err).String())
+		}
+
+		return err
+	}
+}
+
+// Key used to store the span of a query in the gorm statement
+const gormSpanKey = "sentry:span"
+
+// Register gorm callbacks which run every query with a context, e.g.
+// `db.WithContext(ctx)`, inside a `db.sql.query` span.
+func RegisterGorm(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		if tx.Statement.Context == nil || sentry.SpanFromContext(tx.Statement.Context) == nil {
+			return
+		}
+
+		span := StartSpan(tx.Statement.Context, "db.sql.query", tx.Statement.Table)
+		span.SetData("db.system", tx.Dialector.Name())
+		tx.InstanceSet(gormSpanKey, span)
+	}
+
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(gormSpanKey)
+		if !ok {
+			return
+		}
+
+		span := value.(*sentry.Span)
+		span.Description = tx.Statement.SQL.String()
+		span.SetData("db.rows_affected", fmt.Sprint(tx.Statement.RowsAffected))
+		if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+			SetError(span, tx.Error)
+		} else {
+			SetError(span, nil)
+		}
+		span.Finish()
+	}
+
+	callbacks := db.Callback()
+	for name, register := range map[string]func(before, after func(*gorm.DB)) error{
+		"create": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Create().Before("gorm:create").Register("sentry:before_create", before); err != nil {
+				return err
+			}
+			return callbacks.Create().After("gorm:create").Register("sentry:after_create", after)
+		},
+		"query": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Query().Before("gorm:query").Register("sentry:before_query", before); err != nil {
+				return err
+			}
+			return callbacks.Query().After("gorm:query").Register("sentry:after_query", after)
+		},
+		"update": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Update().Before("gorm:update").Register("sentry:before_update", before); err != nil {
+				return err
+			}
+			return callbacks.Update().After("gorm:update").Register("sentry:after_update", after)
+		},
+		"delete": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Delete().Before("gorm:delete").Register("sentry:before_delete", before); err != nil {
+				return err
+			}
+			return callbacks.Delete().After("gorm:delete").Register("sentry:after_delete", after)
+		},
+		"row": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Row().Before("gorm:row").Register("sentry:before_row", before); err != nil {
+				return err
+			}
+			return callbacks.Row().After("gorm:row").Register("sentry:after_row", after)
+		},
+		"raw": func(before, after func(*gorm.DB)) error {
+			if err := callbacks.Raw().Before("gorm:raw").Register("sentry:before_raw", before); err != nil {
+				return err
+			}
+			return callbacks.Raw().After("gorm:raw").Register("sentry:after_raw", after)
+		},
+	} {
+		if err := register(before, after); err != nil {
+			return fmt.Errorf("Can't register the %s callbacks: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
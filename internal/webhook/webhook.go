@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	acmehttp "github.com/acme-sky/workers/internal/http"
+	"github.com/acme-sky/workers/internal/message"
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
+	"github.com/charmbracelet/log"
+	"github.com/knadh/koanf/v2"
+)
+
+// Secrets of the webhook senders. An endpoint whose secret is empty is not
+// served.
+type Config struct {
+	// Key of the signatures of the bank callbacks, e.g. `WEBHOOK_BANK_SECRET`
+	BankSecret string
+
+	// Token shared by the airlines, e.g. `WEBHOOK_AIRLINE_TOKEN`
+	AirlineToken string
+}
+
+// Returns the webhook config from `conf`
+func ConfigFromConfig(conf *koanf.Koanf) Config {
+	return Config{
+		BankSecret:   conf.String("webhook.bank.secret"),
+		AirlineToken: conf.String("webhook.airline.token"),
+	}
+}
+
+// Max size of a webhook body
+var maxBodySize int64 = 1 << 20
+
+// Translate the body of a webhook into a process message
+type translator func(r *http.Request, body []byte) (*message.MessageBody, error)
+
+// Returns true if the request is sent by the sender of the webhook. It can set
+// the headers of `w`, e.g. the expected authentication scheme.
+type authorizer func(w http.ResponseWriter, r *http.Request) bool
+
+// Returns the handler of the webhook endpoints, which publish a Zeebe message
+// with `client` for every authenticated request:
+//
+//   - `POST /webhooks/bank/<offer_id>/<signature>/`, the callback of a payment
+//     returned by `BankCallback`
+//   - `POST /webhooks/airlines/last-minute-offers/`
+func Handler(client *zbc.Client, config Config) http.Handler {
+	mux := http.NewServeMux()
+
+	if len(config.BankSecret) > 0 {
+		mux.Handle("POST /webhooks/bank/{offer_id}/{signature}/{$}", endpoint("bank", signed(config.BankSecret), client, paymentResponse))
+	} else {
+		log.Warn("[Webhook] `WEBHOOK_BANK_SECRET` is not set, the bank callbacks are disabled")
+	}
+
+	if len(config.AirlineToken) > 0 {
+		mux.Handle("POST /webhooks/airlines/last-minute-offers/{$}", endpoint("last_minute_offer", bearer(config.AirlineToken), client, lastMinuteOffer))
+	} else {
+		log.Warn("[Webhook] `WEBHOOK_AIRLINE_TOKEN` is not set, the airline webhooks are disabled")
+	}
+
+	return mux
+}
+
+// Returns the callback of the payment of `offerId` under `base`, e.g. the
+// `BANK_CALLBACK`, signed with `secret` so the webhook can check it's been
+// created by the workers
+func BankCallback(base string, secret string, offerId uint) string {
+	return fmt.Sprintf("%s/%d/%s/", base, offerId, sign(secret, strconv.FormatUint(uint64(offerId), 10)))
+}
+
+// Returns the hex HMAC-SHA256 of `value` with `secret`
+func sign(secret string, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize the requests with the `Authorization: Bearer <token>` header
+func bearer(token string) authorizer {
+	expected := []byte("Bearer " + token)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return false
+		}
+		return true
+	}
+}
+
+// Authorize the requests to a `BankCallback`, whose signature of the offer id
+// must match `secret`
+func signed(secret string) authorizer {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		expected := sign(secret, r.PathValue("offer_id"))
+		return hmac.Equal([]byte(r.PathValue("signature")), []byte(expected))
+	}
+}
+
+// Returns the handler of the endpoint `name`, which authorizes the request,
+// translates the body and publishes the message like the ones consumed from
+// the transport. A message which Zeebe can't receive now returns 503, so the
+// sender can retry it. The caller gets only the status text, the error is
+// logged.
+func endpoint(name string, authorize authorizer, client *zbc.Client, translate translator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := func() (int, error) {
+			if !authorize(w, r) {
+				return http.StatusUnauthorized, errors.New("Invalid credentials")
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				return http.StatusBadRequest, fmt.Errorf("Failed to read the body: %w", err)
+			}
+
+			msg, err := translate(r, body)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+
+			// The sender retries, so there is a single attempt
+			err = message.Process(r.Context(), client, message.Delivery{Body: data, Attempt: 1, MaxAttempts: 1})
+			if err == nil {
+				return http.StatusAccepted, nil
+			}
+
+			var rejected *message.RejectError
+			if errors.As(err, &rejected) && rejected.Reason != message.ReasonPublishFailed {
+				return http.StatusUnprocessableEntity, err
+			}
+			return http.StatusServiceUnavailable, err
+		}()
+
+		metrics.InboundWebhooks.WithLabelValues(name, strconv.Itoa(status)).Inc()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err != nil {
+			log.Warn("[Webhook] Request failed", "webhook", name, "status", status, "err", err)
+			json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status)})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+	})
+}
+
+// Translate the payment posted by the bank to the callback of an offer into
+// `CM_Payment_Response`, correlated by the offer id
+func paymentResponse(r *http.Request, body []byte) (*message.MessageBody, error) {
+	offerId, err := strconv.Atoi(r.PathValue("offer_id"))
+	if err != nil || offerId <= 0 {
+		return nil, fmt.Errorf("Invalid offer id `%s`", r.PathValue("offer_id"))
+	}
+
+	var payment acmehttp.PaymentResponseBody
+	if err := json.Unmarshal(body, &payment); err != nil {
+		return nil, fmt.Errorf("Invalid payment: %w", err)
+	}
+
+	status := "KO"
+	if payment.Paid {
+		status = "OK"
+	}
+
+	return &message.MessageBody{
+		Name:                "CM_Payment_Response",
+		CorrelationVariable: "offer_id",
+		Payload: map[string]interface{}{
+			"offer_id":       offerId,
+			"payment_status": status,
+		},
+	}, nil
+}
+
+// Translate the flight posted by an airline into
+// `CM_Received_Last_Minute_Offer`, correlated by the flight code
+func lastMinuteOffer(r *http.Request, body []byte) (*message.MessageBody, error) {
+	flight, err := decodeObject(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message.MessageBody{
+		Name:                "CM_Received_Last_Minute_Offer",
+		CorrelationVariable: "flight.code",
+		Payload:             map[string]interface{}{"flight": flight},
+	}, nil
+}
+
+// Decode a JSON object, which is then checked against the message schema
+func decodeObject(body []byte) (map[string]interface{}, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(body, &object); err != nil || object == nil {
+		return nil, errors.New("Body must be a JSON object")
+	}
+
+	return object, nil
+}
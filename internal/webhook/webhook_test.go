@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBankCallbackSignature(t *testing.T) {
+	handler := Handler(nil, Config{BankSecret: "secret"})
+	callback := BankCallback("http://workers/webhooks/bank", "secret", 42)
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{name: "signed callback", url: callback, status: http.StatusBadRequest},
+		{name: "other offer", url: strings.Replace(callback, "/42/", "/43/", 1), status: http.StatusUnauthorized},
+		{name: "other secret", url: BankCallback("http://workers/webhooks/bank", "other", 42), status: http.StatusUnauthorized},
+		{name: "no signature", url: "http://workers/webhooks/bank/42/", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The body is invalid, so a signed callback stops before Zeebe
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader("{")))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestEndpointHidesErrors(t *testing.T) {
+	handler := Handler(nil, Config{BankSecret: "secret"})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, BankCallback("http://workers/webhooks/bank", "secret", 42), strings.NewReader("{")))
+
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid body: %s", err)
+	}
+	if body["error"] != http.StatusText(http.StatusBadRequest) {
+		t.Errorf("error = %q, want only the status text", body["error"])
+	}
+}
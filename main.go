@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/acme-sky/workers/internal/config"
 	"github.com/acme-sky/workers/internal/db"
-	acmeskyHandlers "github.com/acme-sky/workers/internal/handlers/acmesky"
-	prontogramHandlers "github.com/acme-sky/workers/internal/handlers/prontogram"
-	userHandlers "github.com/acme-sky/workers/internal/handlers/user"
+	"github.com/acme-sky/workers/internal/health"
 	acmejob "github.com/acme-sky/workers/internal/job"
+	"github.com/acme-sky/workers/internal/logging"
 	"github.com/acme-sky/workers/internal/message"
+	"github.com/acme-sky/workers/internal/metrics"
+	"github.com/acme-sky/workers/internal/registry"
+	"github.com/acme-sky/workers/internal/webhook"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/worker"
+	"github.com/camunda/zeebe/clients/go/v8/pkg/zbc"
 	"github.com/charmbracelet/log"
 	"github.com/getsentry/sentry-go"
 )
@@ -29,6 +42,37 @@ func main() {
 	// above.
 	conf, _ := config.GetConfig()
 
+	if err := logging.Setup(conf.String("log.format"), conf.String("log.level")); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Command used by the container healthcheck, it exits with 1 if the
+	// workers are not ready
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		if err := healthcheck(httpAddress(conf.String("http.address"))); err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// Admin command which lists or replays the dead-lettered RabbitMQ messages,
+	// e.g. `./main dlq list -limit 10` or `./main dlq replay`
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		if name := conf.String("message.transport"); len(name) > 0 && name != "rabbitmq" {
+			log.Errorf("`dlq` is only supported by the RabbitMQ transport, not `%s`", name)
+			os.Exit(1)
+		}
+
+		if err := deadLetters(conf.String("rabbitmq.uri"), message.TopologyFromConfig(conf), os.Args[2:]); err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	err := sentry.Init(sentry.ClientOptions{
 		Dsn:              conf.String("sentry.dsn"),
 		TracesSampleRate: 0.7,
@@ -43,70 +87,265 @@ func main() {
 		return
 	}
 
-	client := acmejob.CreateClient(conf.String("process.id"))
-	defer (*client).Close()
+	// Admin command which creates an instance of `PROCESS_ID` and exits, e.g.
+	// `./main create-instance [-force]`
+	if len(os.Args) > 1 && os.Args[1] == "create-instance" {
+		flags := flag.NewFlagSet("create-instance", flag.ExitOnError)
+		force := flags.Bool("force", false, "create a new instance even if one is active")
+		flags.Parse(os.Args[2:])
 
-	signal.Notify(quit, os.Interrupt)
-	go func() {
-		<-quit
-		os.Exit(1)
-	}()
+		client := acmejob.CreateClient()
+		createInstance(client, conf.String("process.id"), *force)
+		if err := (*client).Close(); err != nil {
+			log.Errorf("Error closing the Zeebe client: %s", err.Error())
+		}
+
+		return
+	}
+
+	jobs := registry.Jobs()
 
+	// Check the jobs against every deployed BPMN before deploying them. With
+	// `BPMN_VALIDATION=lenient` the mismatches are logged but the workers start
+	// anyway.
+	lenient := conf.String("bpmn.validation") == "lenient"
+	resources, err := acmejob.DeployedResources()
+	if err != nil {
+		log.Fatalf("Error finding the BPMN resources. err %v", err)
+
+		return
+	}
+
+	warnings, err := acmejob.ValidateRegistry(resources, jobs, lenient)
+	for _, warning := range warnings {
+		log.Warnf("BPMN validation: %s", warning.Error())
+	}
+	if err != nil {
+		log.Fatalf("BPMN validation failed. err %v", err)
+
+		return
+	}
+
+	client := acmejob.CreateClient()
+
+	// Make sure an instance of `PROCESS_ID` is active. With
+	// `PROCESS_BOOTSTRAP=false` instances are created only by the
+	// `create-instance` command.
+	if conf.String("process.bootstrap") != "false" {
+		createInstance(client, conf.String("process.id"), false)
+	}
+
+	// Transport of the process messages and of the domain events emitted by
+	// the handlers, e.g. `MESSAGE_TRANSPORT=nats`
+	transport, err := message.InitTransport(conf)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	// Liveness checks only the state of this process, readiness checks also
+	// the dependencies
+	liveness := health.NewChecker()
+	liveness.Register(transport.Name(), func(ctx context.Context) error {
+		return message.Running()
+	})
+	liveness.Register("workers", func(ctx context.Context) error {
+		return acmejob.WorkersOpen(jobs)
+	})
+
+	readiness := health.NewChecker()
+	readiness.Register("zeebe", func(ctx context.Context) error {
+		topology, err := (*client).NewTopologyCommand().Send(ctx)
+		if err != nil {
+			return err
+		}
+		if len(topology.GetBrokers()) == 0 {
+			return errors.New("Zeebe gateway has no brokers")
+		}
+		return nil
+	})
+	readiness.Register("database", db.Ping)
+	readiness.Register(transport.Name(), func(ctx context.Context) error {
+		return message.Status()
+	})
+	readiness.Register("workers", func(ctx context.Context) error {
+		return acmejob.WorkersStatus(jobs)
+	})
+
+	// HTTP server for the Prometheus metrics and the health checks, e.g.
+	// `HTTP_ADDRESS=:4242`
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", liveness.Handler(2*time.Second))
+	mux.Handle("/readyz", readiness.Handler(2*time.Second))
+	server := &http.Server{Addr: httpAddress(conf.String("http.address")), Handler: mux}
 	go func() {
-		message.MessageBroker(client)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("HTTP server: %s", err.Error())
+		}
 	}()
 
-	jobs := []acmejob.Job{
-		// ------------- USER -------------
-		// First part when an user expresses interest to monitor a flight
-		{Name: "TM_New_Request_Save_Flight", Handler: userHandlers.TMNewRequestSaveFlight, Message: &acmejob.MessageCommand{Name: "CM_New_Request_Save_Flight", CorrelationKey: "0"}},
-		{Name: "TM_Check_Offer", Handler: userHandlers.TMCheckOffer, Message: &acmejob.MessageCommand{Name: "CM_Check_Offer", CorrelationKey: "0"}},
-
-		// ------------- PRONTOGRAM -------------
-		{Name: "ST_Save_Info_On_Prontogram", Handler: prontogramHandlers.STSaveInfoOnProntogram, Message: nil},
-		{Name: "TM_Propagate_Message_From_Prontogram", Handler: prontogramHandlers.TMPropagateMessageFromProntogram, Message: &acmejob.MessageCommand{Name: "Start_Received_New_Offer", CorrelationKey: "0"}},
-
-		// ------------- ACMESKY -------------
-		// First part of User Profile lane
-		{Name: "ST_Save_Flight", Handler: acmeskyHandlers.STSaveFlight},
-		{Name: "TM_Ack_Flight_Request_Save", Handler: acmeskyHandlers.TMAckFlightRequestSave, Message: &acmejob.MessageCommand{Name: "CM_Ack_Flight_Request_Save", CorrelationKey: "0"}},
-
-		// Interests manager lane
-		{Name: "ST_Create_Journeys", Handler: acmeskyHandlers.STCreateJourneys},
-		{Name: "ST_Prepare_Offer", Handler: acmeskyHandlers.STPrepareOffer},
-		{Name: "TM_Send_Offer", Handler: acmeskyHandlers.TMSendOffer, Message: &acmejob.MessageCommand{Name: "CM_New_Message_For_Prontogram", CorrelationKey: "0"}},
-
-		// User profile lane: check offer
-		{Name: "ST_Retrieve_Offer", Handler: acmeskyHandlers.STRetrieveOffer},
-		{Name: "ST_Change_Offer_Status", Handler: acmeskyHandlers.STChangeOfferStatus},
-		{Name: "TM_Error_On_Check_Offer", Handler: acmeskyHandlers.TMErrorOnCheckOffer, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Error", CorrelationKey: "0"}},
-
-		// User profile lane: book journey
-		// Message fields for TM_Book_Journey and TM_Ask_Payment_Link is `nil` because it comunicates with an hidden participant
-		{Name: "TM_Book_Journey", Handler: acmeskyHandlers.TMBookJourney},
-		{Name: "TM_Ask_Payment_Link", Handler: acmeskyHandlers.TMAskPaymentLink},
-		{Name: "TM_Send_Payment_Link", Handler: acmeskyHandlers.TMSendPaymentLink, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Link", CorrelationKey: "0"}},
-		{Name: "ST_Offer_Still_Valid", Handler: acmeskyHandlers.STOfferStillValid},
-		{Name: "TM_Error_On_Book_Journey", Handler: acmeskyHandlers.TMErrorOnBookJourney, Message: &acmejob.MessageCommand{Name: "CM_Received_Bank_Error", CorrelationKey: "0"}},
-		{Name: "TM_Invoice", Handler: acmeskyHandlers.TMInvoice, Message: &acmejob.MessageCommand{Name: "CM_Journey", CorrelationKey: "0"}},
-		{Name: "TM_Compute_Distance_User_Airport", Handler: acmeskyHandlers.TMComputeDistanceUserAirport},
-		{Name: "ST_Sort_Rent_Services", Handler: acmeskyHandlers.STSortRentServices},
-		{Name: "TM_Ask_For_Rent", Handler: acmeskyHandlers.TMAskForRent},
-		{Name: "TM_Invoice_And_Rent", Handler: acmeskyHandlers.TMInvoiceAndRent, Message: &acmejob.MessageCommand{Name: "CM_Journey_And_Rent", CorrelationKey: "0"}},
-		{Name: "TM_Invoice_Rent_Error", Handler: acmeskyHandlers.TMInvoiceRentError, Message: &acmejob.MessageCommand{Name: "CM_Journey", CorrelationKey: "0"}},
-
-		// User profile lane: flights manager
-		{Name: "ST_Save_Last_Minute_Offer", Handler: acmeskyHandlers.STSaveLastMinuteOffer},
-		{Name: "ST_Get_User_Interests", Handler: acmeskyHandlers.STGetUserInterests},
-		{Name: "TM_Search_Flights_On_Airline", Handler: acmeskyHandlers.TMSearchFlightsOnAirline},
-		{Name: "ST_Save_Flights_As_Available", Handler: acmeskyHandlers.STSaveFlightsAsAvailable},
+	// Optional HTTP server for the webhooks of the bank and the airlines, e.g.
+	// `WEBHOOK_ADDRESS=:8080`. It's a separate server so it can be exposed
+	// without the metrics and the health checks.
+	var webhookServer *http.Server
+	if address := conf.String("webhook.address"); len(address) > 0 {
+		webhookServer = &http.Server{
+			Addr:              address,
+			Handler:           webhook.Handler(client, webhook.ConfigFromConfig(conf)),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := webhookServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("Webhook server: %s", err.Error())
+			}
+		}()
 	}
 
+	brokerCtx, stopBroker := context.WithCancel(context.Background())
+	brokerDone := make(chan struct{})
+	go func() {
+		message.MessageBroker(brokerCtx, client)
+		close(brokerDone)
+	}()
+
+	middlewares := acmejob.DefaultMiddlewares()
+	workers := make([]worker.JobWorker, 0, len(jobs))
 	for _, job := range jobs {
-		go func(job *acmejob.Job) {
-			job.Handle(client)
-		}(&job)
+		workers = append(workers, job.Handle(client, middlewares...))
 	}
 
 	<-quit
+	log.Info("Shutting down...")
+
+	// Stop receiving webhooks before closing the Zeebe client
+	if webhookServer != nil {
+		webhookCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := webhookServer.Shutdown(webhookCtx); err != nil {
+			log.Errorf("Error closing the webhook server: %s", err.Error())
+		}
+		cancel()
+	}
+
+	// Max time to wait for the running jobs, e.g. `SHUTDOWN_TIMEOUT=30s`
+	timeout := conf.Duration("shutdown.timeout")
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	if err := acmejob.Shutdown(workers, timeout); err != nil {
+		log.Warnf("Shutdown: %s", err.Error())
+	}
+
+	stopBroker()
+	select {
+	case <-brokerDone:
+	case <-time.After(5 * time.Second):
+		log.Warn("RabbitMQ consumer not closed")
+	}
+
+	if err := message.CloseTransport(); err != nil {
+		log.Errorf("Error closing the message transport: %s", err.Error())
+	}
+
+	if err := (*client).Close(); err != nil {
+		log.Errorf("Error closing the Zeebe client: %s", err.Error())
+	}
+
+	if err := db.CloseDb(); err != nil {
+		log.Errorf("Error closing the database: %s", err.Error())
+	}
+
+	serverCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(serverCtx); err != nil {
+		log.Errorf("Error closing the HTTP server: %s", err.Error())
+	}
+
+	sentry.Flush(2 * time.Second)
+}
+
+// Create an instance of `processId` if there is no active one, or always if
+// `force` is true.
+func createInstance(client *zbc.Client, processId string, force bool) {
+	key, created, err := acmejob.CreateInstance(client, processId, force)
+	if err != nil {
+		log.Fatalf("failed to create an instance of `%s`. err %v", processId, err)
+	}
+
+	if created {
+		log.Infof("Created instance %d of `%s`", key, processId)
+	} else {
+		log.Infof("Instance %d of `%s` is still active", key, processId)
+	}
+}
+
+// Returns the address of the HTTP server, `:4242` if `address` is empty
+func httpAddress(address string) string {
+	if len(address) == 0 {
+		return ":4242"
+	}
+	return address
+}
+
+// Call the readiness endpoint of the HTTP server listening on `address`
+func healthcheck(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(fmt.Sprintf("http://%s/readyz", net.JoinHostPort(host, port)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("Workers not ready: %s", body)
+	}
+
+	return nil
+}
+
+// Run the `dlq` admin command with `args`: `list` prints the dead-lettered
+// messages without removing them, `replay` moves them back to the consumed
+// queue.
+func deadLetters(uri string, topology message.Topology, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: dlq list|replay [-limit N]")
+	}
+
+	flags := flag.NewFlagSet("dlq "+args[0], flag.ExitOnError)
+	limit := flags.Int("limit", 0, "max number of messages, 0 for all")
+	flags.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		letters, err := message.InspectDeadLetters(uri, topology, *limit)
+		if err != nil {
+			return err
+		}
+
+		for _, letter := range letters {
+			fmt.Printf("%s\treason=%s attempts=%d error=%q\n%s\n", letter.FailedAt, letter.Reason, letter.Attempts, letter.Error, letter.Body)
+		}
+		log.Infof("%d dead-lettered messages", len(letters))
+	case "replay":
+		replayed, err := message.ReplayDeadLetters(uri, topology, *limit)
+		log.Infof("%d messages replayed", replayed)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown dlq command `%s`", args[0])
+	}
+
+	return nil
 }